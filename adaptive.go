@@ -0,0 +1,133 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidAdaptiveNoise is returned when the adaptive noise configuration can't be used.
+var ErrInvalidAdaptiveNoise = fmt.Errorf("invalid adaptive noise arguments")
+
+// AdaptiveNoise configures the online estimation of the process and measurement noise.
+//
+// The filter multiplies the process noise covariance by QScale and the measurement noise
+// covariance by RScale, both starting at 1. After each accepted observation, the scales are
+// re-estimated from the innovation and the residual (Sage-Husa style), and the estimates are
+// averaged with the forgetting factor, so the scales follow the noise of the recent observations.
+//
+// Both noises make the innovations larger, so when both scales are adapted and the reported
+// accuracy is wrong, part of the error goes to the process noise scale. To adapt only one of
+// them, set both bounds of the other one to 1.
+type AdaptiveNoise struct {
+	// Forgetting is the forgetting factor, between 0 and 1, such as 0.95. The larger it is,
+	// the more observations the scales are averaged over.
+	Forgetting float64
+	// MinQScale and MaxQScale are the bounds of the process noise scale.
+	MinQScale, MaxQScale float64
+	// MinRScale and MaxRScale are the bounds of the measurement noise scale.
+	MinRScale, MaxRScale float64
+}
+
+// adaptation is the state of the noise estimation.
+type adaptation struct {
+	conf   AdaptiveNoise
+	qScale float64    // Process noise scale.
+	rScale float64    // Measurement noise scale.
+	q      *mat.Dense // Scaled process noise accumulated since the last update.
+	steps  int        // Number of updates the scales were estimated on.
+}
+
+// valid returns true if the configuration can be used.
+func (a *AdaptiveNoise) valid() bool {
+	return a.Forgetting > 0.0 && a.Forgetting < 1.0 &&
+		a.MinQScale > 0.0 && a.MinQScale <= a.MaxQScale &&
+		a.MinRScale > 0.0 && a.MinRScale <= a.MaxRScale
+}
+
+// newAdaptation returns the noise estimation for the state of size n, starting with the scales of 1.
+func newAdaptation(conf AdaptiveNoise, n int) *adaptation {
+	return &adaptation{
+		conf:   conf,
+		qScale: clamp(1.0, conf.MinQScale, conf.MaxQScale),
+		rScale: clamp(1.0, conf.MinRScale, conf.MaxRScale),
+		q:      mat.NewDense(n, n, nil),
+	}
+}
+
+// accumulate adds the prediction with transition f and scaled process noise q.
+func (a *adaptation) accumulate(f, q mat.Matrix) {
+	a.q.Mul(f, a.q)
+	a.q.Mul(a.q, f.T())
+	a.q.Add(a.q, q)
+}
+
+// adapt re-estimates the scales. y is the innovation and hph is H*P*H^T before the update,
+// eps is the residual and hph1 is H*P*H^T after the update, r is the scaled measurement noise.
+func (a *adaptation) adapt(y mat.Vector, hph mat.Matrix, eps mat.Vector, hph1 mat.Matrix, h, r mat.Matrix) {
+	var chol mat.Cholesky
+	if !chol.Factorize(symmetric(r)) {
+		return
+	}
+	m := float64(y.Len())
+
+	// E[y*y^T] = H*(F*P*F^T + Q)*H^T + R, solved for the process noise scale, with the
+	// components weighted by R^-1 to make them comparable.
+	var hq, hqh mat.Dense
+	hq.Mul(h, a.q)
+	hqh.Mul(&hq, h.T())
+	var fpf mat.Dense
+	fpf.Sub(hph, &hqh)
+	qScale := a.qScale
+	if tq := normalizedTrace(&chol, &hqh); tq > 0.0 {
+		qScale *= (normalizedSquare(&chol, y) - normalizedTrace(&chol, &fpf) - m) / tq
+	}
+
+	// E[eps*eps^T] = R - H*P*H^T after the update.
+	rScale := a.rScale * (normalizedSquare(&chol, eps) + normalizedTrace(&chol, hph1)) / m
+
+	d := (1.0 - a.conf.Forgetting) / (1.0 - math.Pow(a.conf.Forgetting, float64(a.steps+1)))
+	a.qScale = clamp((1.0-d)*a.qScale+d*qScale, a.conf.MinQScale, a.conf.MaxQScale)
+	a.rScale = clamp((1.0-d)*a.rScale+d*rScale, a.conf.MinRScale, a.conf.MaxRScale)
+	a.steps++
+}
+
+// copy returns a copy of the noise estimation state.
+func (a *adaptation) copy() *adaptation {
+	c := *a
+	c.q = mat.DenseCopyOf(a.q)
+	return &c
+}
+
+// reset forgets the process noise accumulated since the last update.
+func (a *adaptation) reset() {
+	a.q.Zero()
+}
+
+// normalizedSquare returns y^T*R^-1*y for the factorized R.
+func normalizedSquare(chol *mat.Cholesky, y mat.Vector) float64 {
+	var w mat.VecDense
+	if err := chol.SolveVecTo(&w, y); err != nil {
+		if _, ok := err.(mat.Condition); !ok {
+			return 0.0
+		}
+	}
+	return mat.Dot(y, &w)
+}
+
+// normalizedTrace returns the trace of R^-1*A for the factorized R.
+func normalizedTrace(chol *mat.Cholesky, a mat.Matrix) float64 {
+	var w mat.Dense
+	if err := chol.SolveTo(&w, a); err != nil {
+		if _, ok := err.(mat.Condition); !ok {
+			return 0.0
+		}
+	}
+	return mat.Trace(&w)
+}
+
+// clamp returns v limited to the range from lo to hi.
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
@@ -0,0 +1,127 @@
+package kalman
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/regnull/kalman/geo"
+	"github.com/stretchr/testify/assert"
+)
+
+// simulateAdaptive runs simulateTrack with the adaptive noise.
+func simulateAdaptive(assert *assert.Assertions, conf AdaptiveNoise, noise, truthNoise, accuracy, truthAccuracy float64) *Filter {
+	return simulateTrack(assert, 1000, noise, truthNoise, accuracy, truthAccuracy, nil, WithAdaptiveNoise(conf))
+}
+
+var testAdaptiveNoise = AdaptiveNoise{
+	Forgetting: 0.995,
+	MinQScale:  0.01,
+	MaxQScale:  1000.0,
+	MinRScale:  0.01,
+	MaxRScale:  1000.0,
+}
+
+func TestAdaptiveConsistent(t *testing.T) {
+	assert := assert.New(t)
+	e := simulateAdaptive(assert, testAdaptiveNoise, 1.0, 1.0, 3.0, 3.0).Estimate()
+	// The process noise is a small part of the innovation, so its scale is a noisy estimate.
+	assert.True(e.QScale > 0.3 && e.QScale < 3.0, "%f", e.QScale)
+	assert.InDelta(1.0, e.RScale, 0.3)
+}
+
+func TestAdaptiveMeasurementNoise(t *testing.T) {
+	// The reported accuracy is 3 times better than the real one.
+	assert := assert.New(t)
+	conf := testAdaptiveNoise
+	conf.MinQScale, conf.MaxQScale = 1.0, 1.0
+	e := simulateAdaptive(assert, conf, 1.0, 1.0, 1.0, 3.0).Estimate()
+	assert.Equal(1.0, e.QScale)
+	assert.InDelta(9.0, e.RScale, 1.5)
+}
+
+func TestAdaptiveProcessNoise(t *testing.T) {
+	// The process noise is 10 times smaller than the real one, by the standard deviation.
+	assert := assert.New(t)
+	conf := testAdaptiveNoise
+	conf.MinRScale, conf.MaxRScale = 1.0, 1.0
+	e := simulateAdaptive(assert, conf, 0.1, 1.0, 3.0, 3.0).Estimate()
+	assert.InDelta(100.0, e.QScale, 50.0)
+	assert.Equal(1.0, e.RScale)
+}
+
+func TestAdaptiveBounds(t *testing.T) {
+	assert := assert.New(t)
+	conf := testAdaptiveNoise
+	conf.MinQScale, conf.MaxQScale = 1.0, 1.0
+	conf.MaxRScale = 2.0
+	e := simulateAdaptive(assert, conf, 1.0, 1.0, 1.0, 3.0).Estimate()
+	assert.Equal(2.0, e.RScale)
+
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	assert.Nil(f.adapt)
+	for _, c := range []AdaptiveNoise{
+		{},
+		{Forgetting: 1.0, MinQScale: 1.0, MaxQScale: 1.0, MinRScale: 1.0, MaxRScale: 1.0},
+		{Forgetting: 0.9, MinQScale: 2.0, MaxQScale: 1.0, MinRScale: 1.0, MaxRScale: 1.0},
+		{Forgetting: 0.9, MinQScale: 1.0, MaxQScale: 1.0, MinRScale: 0.0, MaxRScale: 1.0},
+	} {
+		_, err := NewFilter(&ProcessNoise{}, WithAdaptiveNoise(c))
+		assert.Equal(ErrInvalidAdaptiveNoise, err)
+	}
+}
+
+func TestGeoAdaptive(t *testing.T) {
+	// The filter is configured for walking, but it tracks a car that speeds up and slows down,
+	// with the phone reporting better accuracy than the real one.
+	assert := assert.New(t)
+	d := &GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.5,
+		SpeedPerSecond:    0.5,
+	}
+	fixed, err := NewGeoFilter(d)
+	assert.NoError(err)
+	adaptive, err := NewGeoFilter(d, WithAdaptiveNoise(AdaptiveNoise{
+		Forgetting: 0.99,
+		MinQScale:  1.0,
+		MaxQScale:  1000.0,
+		MinRScale:  1.0,
+		MaxRScale:  100.0,
+	}))
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	x, v := 0.0, 0.0
+	var fixedErr, adaptiveErr float64
+	for i := 0; i < 600; i++ {
+		a := 3.0
+		if (i/20)%2 == 1 {
+			a = -3.0
+		}
+		x += v + a/2.0
+		v += a
+		ob := &GeoObserved{
+			Lat:                43.0 + (x+10.0*rnd.NormFloat64())/metersPerDegreeLat,
+			Lng:                -71.0,
+			HorizontalAccuracy: 3.0,
+			Components:         GeoPosition,
+		}
+		_, err := fixed.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = adaptive.Observe(1.0, ob)
+		assert.NoError(err)
+		if i >= 100 {
+			d := (fixed.Estimate().Lat-43.0)*metersPerDegreeLat - x
+			fixedErr += d * d
+			d = (adaptive.Estimate().Lat-43.0)*metersPerDegreeLat - x
+			adaptiveErr += d * d
+		}
+	}
+	e := adaptive.Estimate()
+	assert.True(e.QScale > 10.0, "%f", e.QScale)
+	assert.True(e.RScale > 1.0, "%f", e.RScale)
+	assert.True(adaptiveErr < fixedErr/2.0, "adaptive %f, fixed %f", adaptiveErr, fixedErr)
+	assert.Equal(1.0, fixed.Estimate().QScale)
+	assert.Equal(1.0, fixed.Estimate().RScale)
+}
@@ -0,0 +1,197 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// ErrInvalidWindow is returned when the diagnostics window is not positive.
+var ErrInvalidWindow = fmt.Errorf("diagnostics window must be positive")
+
+// Diagnostics records the consistency statistics of a filter over the last updates:
+// the normalized innovation squared (NIS) of each update, the whitened innovations and
+// the normalized estimation error squared (NEES) for the ground truth supplied with Filter.NEES.
+//
+// For a consistent filter NIS and NEES are chi-square distributed with the number of observed
+// components as degrees of freedom, and the innovations are white. An average above the bounds
+// means the filter is overconfident, below the bounds means it is underconfident.
+type Diagnostics struct {
+	window     int
+	innovation []innovationSample
+	nees       []sample
+}
+
+// sample is a single normalized squared error.
+type sample struct {
+	value float64 // Normalized squared error.
+	dof   int     // Degrees of freedom.
+}
+
+// innovationSample is the innovation of a single update.
+type innovationSample struct {
+	sample
+	components Component   // Observed components.
+	white      [_N]float64 // Whitened innovation, indexed by the component.
+}
+
+// Consistency is the average of a normalized squared error over the window.
+type Consistency struct {
+	Average      float64 // Average normalized squared error.
+	Lower, Upper float64 // Bounds of the average for a consistent filter, with the requested confidence.
+	Samples      int     // Number of samples.
+}
+
+// Consistent returns true if the average is within the bounds.
+func (c Consistency) Consistent() bool {
+	return c.Average >= c.Lower && c.Average <= c.Upper
+}
+
+// Overconfident returns true if the errors are larger than the filter covariance says.
+func (c Consistency) Overconfident() bool {
+	return c.Average > c.Upper
+}
+
+// Underconfident returns true if the errors are smaller than the filter covariance says.
+func (c Consistency) Underconfident() bool {
+	return c.Average < c.Lower
+}
+
+// Whiteness is the result of the innovation autocorrelation test.
+type Whiteness struct {
+	Autocorrelation []float64 // Normalized autocorrelation of the whitened innovations, for lags 1, 2, ...
+	Bounds          []float64 // Bounds of the autocorrelation for white innovations, with the requested confidence.
+}
+
+// White returns true if the autocorrelation is within the bounds for all the lags.
+func (w Whiteness) White() bool {
+	for i, a := range w.Autocorrelation {
+		if math.Abs(a) > w.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newDiagnostics returns the diagnostics recording the last window updates.
+func newDiagnostics(window int) *Diagnostics {
+	return &Diagnostics{window: window}
+}
+
+// NIS returns the recorded normalized innovation squared values, oldest first.
+func (d *Diagnostics) NIS() []float64 {
+	res := make([]float64, len(d.innovation))
+	for i := range d.innovation {
+		res[i] = d.innovation[i].value
+	}
+	return res
+}
+
+// NEES returns the recorded normalized estimation error squared values, oldest first.
+func (d *Diagnostics) NEES() []float64 {
+	res := make([]float64, len(d.nees))
+	for i := range d.nees {
+		res[i] = d.nees[i].value
+	}
+	return res
+}
+
+// AverageNIS returns the average NIS with its bounds for the given confidence, such as 0.95.
+func (d *Diagnostics) AverageNIS(confidence float64) Consistency {
+	samples := make([]sample, len(d.innovation))
+	for i := range d.innovation {
+		samples[i] = d.innovation[i].sample
+	}
+	return consistency(samples, confidence)
+}
+
+// AverageNEES returns the average NEES with its bounds for the given confidence, such as 0.95.
+// The bounds assume independent samples, while the errors of a single track are correlated
+// in time, so a slightly larger confidence is appropriate.
+func (d *Diagnostics) AverageNEES(confidence float64) Consistency {
+	return consistency(d.nees, confidence)
+}
+
+// Whiteness returns the autocorrelation of the whitened innovations for lags 1 to lags,
+// with the bounds for the given confidence, such as 0.95.
+func (d *Diagnostics) Whiteness(lags int, confidence float64) Whiteness {
+	z := distuv.UnitNormal.Quantile((1.0 + confidence) / 2.0)
+	res := Whiteness{
+		Autocorrelation: make([]float64, lags),
+		Bounds:          make([]float64, lags),
+	}
+	for k := 1; k <= lags; k++ {
+		var sum, sumA, sumB float64
+		n := 0
+		for t := 0; t+k < len(d.innovation); t++ {
+			a := &d.innovation[t]
+			b := &d.innovation[t+k]
+			both := a.components & b.components
+			for i := 0; i < _N; i++ {
+				if both&(1<<uint(i)) == 0 {
+					continue
+				}
+				sum += a.white[i] * b.white[i]
+				sumA += a.white[i] * a.white[i]
+				sumB += b.white[i] * b.white[i]
+				n++
+			}
+		}
+		if n == 0 || sumA == 0.0 || sumB == 0.0 {
+			res.Bounds[k-1] = math.Inf(1)
+			continue
+		}
+		res.Autocorrelation[k-1] = sum / math.Sqrt(sumA*sumB)
+		res.Bounds[k-1] = z / math.Sqrt(float64(n))
+	}
+	return res
+}
+
+// addInnovation records the NIS and the whitened innovation, nil if unknown, of the measurement.
+func (d *Diagnostics) addInnovation(nis float64, m *measurement, white []float64) {
+	smp := innovationSample{
+		sample:     sample{value: nis, dof: len(m.idx)},
+		components: m.components(),
+	}
+	if white != nil {
+		for j, i := range m.idx {
+			smp.white[i] = white[j]
+		}
+	}
+	if len(d.innovation) == d.window {
+		copy(d.innovation, d.innovation[1:])
+		d.innovation = d.innovation[:len(d.innovation)-1]
+	}
+	d.innovation = append(d.innovation, smp)
+}
+
+// addNEES records a NEES value.
+func (d *Diagnostics) addNEES(nees float64, dof int) {
+	if len(d.nees) == d.window {
+		copy(d.nees, d.nees[1:])
+		d.nees = d.nees[:len(d.nees)-1]
+	}
+	d.nees = append(d.nees, sample{value: nees, dof: dof})
+}
+
+// consistency returns the average of the samples with the chi-square bounds for the given confidence.
+func consistency(samples []sample, confidence float64) Consistency {
+	if len(samples) == 0 {
+		return Consistency{}
+	}
+	var sum float64
+	dof := 0
+	for _, s := range samples {
+		sum += s.value
+		dof += s.dof
+	}
+	n := float64(len(samples))
+	chi2 := distuv.ChiSquared{K: float64(dof)}
+	return Consistency{
+		Average: sum / n,
+		Lower:   chi2.Quantile((1.0-confidence)/2.0) / n,
+		Upper:   chi2.Quantile((1.0+confidence)/2.0) / n,
+		Samples: len(samples),
+	}
+}
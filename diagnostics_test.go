@@ -0,0 +1,147 @@
+package kalman
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+// simulateTrack runs a filter with the given process noise, measurement accuracy and options
+// over a constant velocity track with process noise truthNoise and measurement accuracy
+// truthAccuracy, for the given number of steps. If step is not nil, it's called with the true
+// state after each observation.
+func simulateTrack(assert *assert.Assertions, steps int, noise, truthNoise, accuracy, truthAccuracy float64,
+	step func(f *Filter, truth *mat.VecDense), opts ...Option) *Filter {
+	rnd := rand.New(rand.NewSource(1))
+	truthModel, err := NewConstantVelocity(&ProcessNoise{SVX: truthNoise, SVY: truthNoise, SVZ: truthNoise, ST: 1.0})
+	assert.NoError(err)
+	f, err := NewFilter(&ProcessNoise{SVX: noise, SVY: noise, SVZ: noise, ST: 1.0}, opts...)
+	assert.NoError(err)
+
+	truth := mat.NewVecDense(_N, nil)
+	tr := mat.NewDense(_N, _N, nil)
+	truthModel.Transition(tr, 1.0)
+	q := mat.NewDense(_N, _N, nil)
+	truthModel.ProcessNoise(q, 1.0)
+	sq := sqrtPSD(q)
+	w := mat.NewVecDense(_N, nil)
+	for i := 0; i < steps; i++ {
+		for j := 0; j < _N; j++ {
+			w.SetVec(j, rnd.NormFloat64())
+		}
+		w.MulVec(sq, w)
+		truth.MulVec(tr, truth)
+		truth.AddVec(truth, w)
+		_, err := f.Observe(1.0, &Observed{
+			X:          truth.AtVec(_X) + truthAccuracy*rnd.NormFloat64(),
+			Y:          truth.AtVec(_Y) + truthAccuracy*rnd.NormFloat64(),
+			Z:          truth.AtVec(_Z) + truthAccuracy*rnd.NormFloat64(),
+			XA:         accuracy,
+			YA:         accuracy,
+			ZA:         accuracy,
+			Components: ComponentPosition,
+		})
+		assert.NoError(err)
+		if step != nil {
+			step(f, truth)
+		}
+	}
+	return f
+}
+
+// simulateDiagnostics runs simulateTrack with the diagnostics, recording the NEES for the true
+// state on each step.
+func simulateDiagnostics(assert *assert.Assertions, noise, truthNoise, accuracy, truthAccuracy float64) *Diagnostics {
+	nees := func(f *Filter, truth *mat.VecDense) {
+		_, err := f.NEES(&Observed{
+			X:  truth.AtVec(_X),
+			Y:  truth.AtVec(_Y),
+			Z:  truth.AtVec(_Z),
+			VX: truth.AtVec(_VX),
+			VY: truth.AtVec(_VY),
+			VZ: truth.AtVec(_VZ),
+		})
+		assert.NoError(err)
+	}
+	return simulateTrack(assert, 300, noise, truthNoise, accuracy, truthAccuracy, nees, WithDiagnostics(200)).Diagnostics()
+}
+
+func TestDiagnosticsConsistent(t *testing.T) {
+	assert := assert.New(t)
+	d := simulateDiagnostics(assert, 1.0, 1.0, 3.0, 3.0)
+	assert.Len(d.NIS(), 200)
+	assert.Len(d.NEES(), 200)
+
+	nis := d.AverageNIS(0.95)
+	assert.Equal(200, nis.Samples)
+	assert.InDelta(3.0, nis.Average, 0.3)
+	assert.True(nis.Consistent(), "%+v", nis)
+	nees := d.AverageNEES(0.99)
+	assert.InDelta(6.0, nees.Average, 0.6)
+	assert.True(nees.Consistent(), "%+v", nees)
+	assert.True(d.Whiteness(3, 0.95).White(), "%+v", d.Whiteness(3, 0.95))
+}
+
+func TestDiagnosticsOverconfident(t *testing.T) {
+	// The filter believes the measurements are 3 times more accurate than they are.
+	assert := assert.New(t)
+	d := simulateDiagnostics(assert, 1.0, 1.0, 1.0, 3.0)
+	assert.True(d.AverageNIS(0.95).Overconfident())
+	assert.True(d.AverageNEES(0.95).Overconfident())
+}
+
+func TestDiagnosticsUnderconfident(t *testing.T) {
+	// The filter believes the measurements are 3 times less accurate than they are.
+	assert := assert.New(t)
+	d := simulateDiagnostics(assert, 1.0, 1.0, 9.0, 3.0)
+	assert.True(d.AverageNIS(0.95).Underconfident())
+}
+
+func TestDiagnosticsNotWhite(t *testing.T) {
+	// The filter believes the speed barely changes, so it lags behind and the innovations
+	// are correlated.
+	assert := assert.New(t)
+	d := simulateDiagnostics(assert, 0.01, 1.0, 3.0, 3.0)
+	w := d.Whiteness(3, 0.95)
+	assert.False(w.White())
+	assert.True(w.Autocorrelation[0] > w.Bounds[0])
+}
+
+func TestDiagnosticsDisabled(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	assert.Nil(f.Diagnostics())
+	_, err = NewFilter(&ProcessNoise{}, WithDiagnostics(0))
+	assert.Equal(ErrInvalidWindow, err)
+}
+
+func TestGeoDiagnostics(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	}, WithDiagnostics(10))
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		HorizontalAccuracy: 10.0,
+		Components:         GeoPosition,
+	}
+	for i := 0; i < 20; i++ {
+		res, err := g.Observe(1.0, ob)
+		assert.NoError(err)
+		if i > 0 {
+			assert.Equal(res.NIS, g.Diagnostics().NIS()[len(g.Diagnostics().NIS())-1])
+		}
+	}
+	assert.Len(g.Diagnostics().NIS(), 10)
+	nees, err := g.NEES(ob)
+	assert.NoError(err)
+	assert.InDelta(0.0, nees, 1e-9)
+	assert.Equal([]float64{nees}, g.Diagnostics().NEES())
+}
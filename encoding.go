@@ -0,0 +1,351 @@
+package kalman
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// encodingVersion is the version of the serialized filter format.
+const encodingVersion = 1
+
+// ErrUnsupportedModel is returned when the filter with a custom motion model is serialized.
+var ErrUnsupportedModel = fmt.Errorf("motion model can't be serialized")
+
+// ErrInvalidEncoding is returned when the serialized filter can't be decoded.
+var ErrInvalidEncoding = fmt.Errorf("invalid serialized filter")
+
+// ErrNotFinite is returned when the filter with a NaN or infinite state is serialized.
+var ErrNotFinite = fmt.Errorf("filter state is not finite")
+
+// filterData is the serialized filter.
+type filterData struct {
+	Version     int              `json:"version"`
+	Model       modelData        `json:"model"`
+	Options     optionsData      `json:"options"`
+	State       []float64        `json:"state,omitempty"`
+	Cov         []float64        `json:"cov,omitempty"`     // Row major.
+	SqrtCov     []float64        `json:"sqrtCov,omitempty"` // Row major, only for the square root filter.
+	Adaptation  *adaptationData  `json:"adaptation,omitempty"`
+	Diagnostics *diagnosticsData `json:"diagnostics,omitempty"`
+	LastUpdate  *time.Time       `json:"lastUpdate,omitempty"` // Nil if unknown.
+}
+
+// modelData is the serialized built-in motion model.
+type modelData struct {
+	Order int           `json:"order"` // 0 is constant position, 1 is constant velocity, 2 is constant acceleration.
+	Q     [3][3]float64 `json:"q"`     // Spectral densities, by order and axis.
+}
+
+// optionsData is the serialized filter configuration.
+type optionsData struct {
+	UpdateForm      UpdateForm     `json:"updateForm"`
+	SquareRoot      bool           `json:"squareRoot,omitempty"`
+	FixedSize       bool           `json:"fixedSize,omitempty"`
+	GateProbability float64        `json:"gateProbability,omitempty"` // Zero means no gate.
+	GateAction      GateAction     `json:"gateAction,omitempty"`
+	Window          int            `json:"window,omitempty"` // Zero means no diagnostics.
+	Adaptive        *AdaptiveNoise `json:"adaptive,omitempty"`
+}
+
+// adaptationData is the serialized noise estimation state.
+type adaptationData struct {
+	QScale float64   `json:"qScale"`
+	RScale float64   `json:"rScale"`
+	Q      []float64 `json:"q"` // Row major.
+	Steps  int       `json:"steps"`
+}
+
+// diagnosticsData is the serialized consistency statistics.
+type diagnosticsData struct {
+	Innovation []innovationData `json:"innovation,omitempty"`
+	NEES       []sampleData     `json:"nees,omitempty"`
+}
+
+// sampleData is the serialized normalized squared error.
+type sampleData struct {
+	Value float64 `json:"value"`
+	DOF   int     `json:"dof"`
+}
+
+// innovationData is the serialized innovation of a single update.
+type innovationData struct {
+	Value      float64     `json:"value"`
+	DOF        int         `json:"dof"`
+	Components Component   `json:"components"`
+	White      [_N]float64 `json:"white"`
+}
+
+// MarshalBinary encodes the filter state and configuration. Only the filters with the built-in
+// motion models can be encoded.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	d, err := f.data()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the filter with the one encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	var d filterData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+		return ErrInvalidEncoding
+	}
+	return f.setData(&d)
+}
+
+// MarshalJSON encodes the filter state and configuration as JSON. Only the filters with
+// the built-in motion models can be encoded.
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	d, err := f.data()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(d)
+}
+
+// UnmarshalJSON replaces the filter with the one encoded by MarshalJSON.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	var d filterData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return ErrInvalidEncoding
+	}
+	return f.setData(&d)
+}
+
+// MarshalBinary encodes the filter state and configuration.
+func (g *GeoFilter) MarshalBinary() ([]byte, error) {
+	return g.filter.MarshalBinary()
+}
+
+// UnmarshalBinary replaces the filter with the one encoded by MarshalBinary.
+func (g *GeoFilter) UnmarshalBinary(data []byte) error {
+	f := &Filter{}
+	if err := f.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if !geoModel(f.model) {
+		return ErrInvalidEncoding
+	}
+	g.filter = f
+	return nil
+}
+
+// MarshalJSON encodes the filter state and configuration as JSON.
+func (g *GeoFilter) MarshalJSON() ([]byte, error) {
+	return g.filter.MarshalJSON()
+}
+
+// UnmarshalJSON replaces the filter with the one encoded by MarshalJSON.
+func (g *GeoFilter) UnmarshalJSON(data []byte) error {
+	f := &Filter{}
+	if err := f.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	if !geoModel(f.model) {
+		return ErrInvalidEncoding
+	}
+	g.filter = f
+	return nil
+}
+
+// geoModel returns true if the motion model can be used by GeoFilter.
+func geoModel(m MotionModel) bool {
+	switch m.(type) {
+	case *ConstantVelocity, *ConstantAcceleration:
+		return true
+	}
+	return false
+}
+
+// data returns the serialized filter.
+func (f *Filter) data() (*filterData, error) {
+	var k *kinematic
+	switch m := f.model.(type) {
+	case *ConstantPosition:
+		k = &m.kinematic
+	case *ConstantVelocity:
+		k = &m.kinematic
+	case *ConstantAcceleration:
+		k = &m.kinematic
+	default:
+		return nil, ErrUnsupportedModel
+	}
+	d := &filterData{
+		Version: encodingVersion,
+		Model:   modelData{Order: k.order, Q: k.q},
+		Options: optionsData{
+			UpdateForm: f.opts.updateForm,
+			SquareRoot: f.opts.squareRoot,
+			FixedSize:  f.opts.fixedSize,
+			Adaptive:   f.opts.adaptive,
+		},
+	}
+	if f.opts.gate != nil {
+		d.Options.GateProbability = f.opts.gate.probability
+		d.Options.GateAction = f.opts.gate.action
+	}
+	if f.state != nil {
+		if !finite(f.state.RawVector().Data) || !finite(f.cov.RawMatrix().Data) {
+			return nil, ErrNotFinite
+		}
+		d.State = append([]float64(nil), f.state.RawVector().Data...)
+		d.Cov = rawData(f.cov)
+		if f.sqrtCov != nil {
+			d.SqrtCov = rawData(f.sqrtCov)
+		}
+	}
+	if !f.last.IsZero() {
+		last := f.last
+		d.LastUpdate = &last
+	}
+	if a := f.adapt; a != nil {
+		d.Adaptation = &adaptationData{QScale: a.qScale, RScale: a.rScale, Q: rawData(a.q), Steps: a.steps}
+	}
+	if diag := f.diag; diag != nil {
+		d.Options.Window = diag.window
+		d.Diagnostics = &diagnosticsData{}
+		for _, s := range diag.innovation {
+			d.Diagnostics.Innovation = append(d.Diagnostics.Innovation, innovationData{
+				Value:      s.value,
+				DOF:        s.dof,
+				Components: s.components,
+				White:      s.white,
+			})
+		}
+		for _, s := range diag.nees {
+			d.Diagnostics.NEES = append(d.Diagnostics.NEES, sampleData{Value: s.value, DOF: s.dof})
+		}
+	}
+	return d, nil
+}
+
+// setData replaces the filter with the serialized one.
+func (f *Filter) setData(d *filterData) error {
+	if d.Version != encodingVersion {
+		return ErrInvalidEncoding
+	}
+	var opts []Option
+	if d.Options.UpdateForm != JosephForm {
+		opts = append(opts, WithUpdateForm(d.Options.UpdateForm))
+	}
+	if d.Options.SquareRoot {
+		opts = append(opts, WithSquareRoot())
+	}
+	if d.Options.FixedSize {
+		opts = append(opts, WithFixedSize())
+	}
+	if d.Options.GateProbability != 0.0 {
+		opts = append(opts, WithInnovationGate(d.Options.GateProbability, d.Options.GateAction))
+	}
+	if d.Options.Window != 0 {
+		opts = append(opts, WithDiagnostics(d.Options.Window))
+	}
+	if d.Options.Adaptive != nil {
+		opts = append(opts, WithAdaptiveNoise(*d.Options.Adaptive))
+	}
+	if d.Model.Order < 0 || d.Model.Order > 2 {
+		return ErrInvalidEncoding
+	}
+	for _, q := range d.Model.Q {
+		for _, v := range q {
+			if !(v >= 0.0) || math.IsInf(v, 1) {
+				return ErrInvalidEncoding
+			}
+		}
+	}
+	k := kinematic{order: d.Model.Order, q: d.Model.Q}
+	var m MotionModel
+	switch k.order {
+	case 0:
+		m = &ConstantPosition{k}
+	case 1:
+		m = &ConstantVelocity{k}
+	case 2:
+		m = &ConstantAcceleration{k}
+	}
+	res, err := NewFilterWithModel(m, opts...)
+	if err != nil {
+		return ErrInvalidEncoding
+	}
+	n := m.Dim()
+	if d.State != nil {
+		if len(d.State) != n || len(d.Cov) != n*n || !finite(d.State) {
+			return ErrInvalidEncoding
+		}
+		cov := mat.NewDense(n, n, d.Cov)
+		if !isPSD(cov) {
+			return ErrInvalidEncoding
+		}
+		res.reset(mat.NewVecDense(n, d.State), cov)
+		if d.SqrtCov != nil {
+			if len(d.SqrtCov) != n*n || !res.opts.squareRoot || !finite(d.SqrtCov) {
+				return ErrInvalidEncoding
+			}
+			res.sqrtCov = mat.NewDense(n, n, d.SqrtCov)
+		}
+	}
+	if a := d.Adaptation; a != nil && res.adapt != nil {
+		conf := res.adapt.conf
+		if len(a.Q) != n*n || !isPSD(mat.NewDense(n, n, a.Q)) || a.Steps < 0 ||
+			!(a.QScale >= conf.MinQScale && a.QScale <= conf.MaxQScale) ||
+			!(a.RScale >= conf.MinRScale && a.RScale <= conf.MaxRScale) {
+			return ErrInvalidEncoding
+		}
+		res.adapt.qScale = a.QScale
+		res.adapt.rScale = a.RScale
+		res.adapt.q = mat.NewDense(n, n, a.Q)
+		res.adapt.steps = a.Steps
+	}
+	if diag := d.Diagnostics; diag != nil && res.diag != nil {
+		if len(diag.Innovation) > res.diag.window || len(diag.NEES) > res.diag.window {
+			return ErrInvalidEncoding
+		}
+		for _, s := range diag.Innovation {
+			if !validSample(s.Value, s.DOF) || !finite(s.White[:]) {
+				return ErrInvalidEncoding
+			}
+			res.diag.innovation = append(res.diag.innovation, innovationSample{
+				sample:     sample{value: s.Value, dof: s.DOF},
+				components: s.Components,
+				white:      s.White,
+			})
+		}
+		for _, s := range diag.NEES {
+			if !validSample(s.Value, s.DOF) {
+				return ErrInvalidEncoding
+			}
+			res.diag.nees = append(res.diag.nees, sample{value: s.Value, dof: s.DOF})
+		}
+	}
+	if d.LastUpdate != nil {
+		res.last = *d.LastUpdate
+	}
+	*f = *res
+	return nil
+}
+
+// validSample returns true if the normalized squared error can be restored.
+func validSample(value float64, dof int) bool {
+	return value >= 0.0 && !math.IsInf(value, 1) && dof > 0
+}
+
+// rawData returns a copy of the matrix elements in row major order.
+func rawData(m *mat.Dense) []float64 {
+	r, c := m.Dims()
+	res := make([]float64, 0, r*c)
+	for i := 0; i < r; i++ {
+		res = append(res, m.RawRowView(i)...)
+	}
+	return res
+}
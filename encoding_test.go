@@ -0,0 +1,276 @@
+package kalman
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+// randomObserved returns a noisy observation of the point moving along X at unit speed, at step i.
+func randomObserved(rnd *rand.Rand, i int) *Observed {
+	return &Observed{
+		X:          float64(i) + rnd.NormFloat64(),
+		Y:          rnd.NormFloat64(),
+		Z:          rnd.NormFloat64(),
+		VX:         1.0 + 0.1*rnd.NormFloat64(),
+		XA:         1.0,
+		YA:         1.0,
+		ZA:         1.0,
+		VXA:        0.1,
+		VYA:        0.1,
+		VZA:        0.1,
+		Components: ComponentPosition | ComponentVX,
+	}
+}
+
+func TestFilterBinaryRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	f, err := NewFilter(d,
+		WithInnovationGate(0.999, InflateOutliers),
+		WithDiagnostics(5),
+		WithAdaptiveNoise(AdaptiveNoise{Forgetting: 0.9, MinQScale: 0.1, MaxQScale: 10.0, MinRScale: 1.0, MaxRScale: 1.0}))
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		_, err = f.Observe(1.0, randomObserved(rnd, i))
+		assert.NoError(err)
+	}
+	data, err := f.MarshalBinary()
+	assert.NoError(err)
+	var g Filter
+	assert.NoError(g.UnmarshalBinary(data))
+	assert.Equal(f.Estimate(), g.Estimate())
+	assert.Equal(f.Diagnostics().NIS(), g.Diagnostics().NIS())
+
+	// The restored filter processes the following observations the same way.
+	for i := 10; i < 20; i++ {
+		ob := randomObserved(rnd, i)
+		r1, err := f.Observe(1.0, ob)
+		assert.NoError(err)
+		r2, err := g.Observe(1.0, ob)
+		assert.NoError(err)
+		assert.Equal(r1, r2)
+	}
+	assert.Equal(f.Estimate(), g.Estimate())
+	assert.Equal(f.Diagnostics().Whiteness(2, 0.95), g.Diagnostics().Whiteness(2, 0.95))
+}
+
+func TestFilterJSONRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	for _, opts := range [][]Option{
+		nil,
+		{WithSquareRoot()},
+		{WithUpdateForm(SimpleForm)},
+	} {
+		m, err := NewConstantAcceleration(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, SAX: 0.01, SAY: 0.01, SAZ: 0.01, ST: 1.0})
+		assert.NoError(err)
+		f, err := NewFilterWithModel(m, opts...)
+		assert.NoError(err)
+
+		// Not initialized yet.
+		data, err := json.Marshal(f)
+		assert.NoError(err)
+		var g Filter
+		assert.NoError(json.Unmarshal(data, &g))
+		assert.False(g.Initialized())
+		assert.Equal(f.model, g.model)
+
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 10; i++ {
+			_, err = f.Observe(1.0, randomObserved(rnd, i))
+			assert.NoError(err)
+		}
+		data, err = json.Marshal(f)
+		assert.NoError(err)
+		assert.NoError(json.Unmarshal(data, &g))
+		for i := 10; i < 20; i++ {
+			ob := randomObserved(rnd, i)
+			_, err = f.Observe(1.0, ob)
+			assert.NoError(err)
+			_, err = g.Observe(1.0, ob)
+			assert.NoError(err)
+		}
+		assert.Equal(f.Estimate(), g.Estimate())
+	}
+}
+
+func TestGeoFilterRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	})
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Altitude:           100.0,
+		Speed:              5.0,
+		SpeedAccuracy:      0.5,
+		Direction:          30.0,
+		DirectionAccuracy:  5.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   5.0,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+
+	data, err := f.MarshalBinary()
+	assert.NoError(err)
+	var g GeoFilter
+	assert.NoError(g.UnmarshalBinary(data))
+	data, err = json.Marshal(f)
+	assert.NoError(err)
+	var h GeoFilter
+	assert.NoError(json.Unmarshal(data, &h))
+	for i := 0; i < 5; i++ {
+		ob.Lat += 0.0001
+		for _, x := range []*GeoFilter{f, &g, &h} {
+			_, err = x.Observe(1.0, ob)
+			assert.NoError(err)
+		}
+	}
+	assert.Equal(f.Estimate(), g.Estimate())
+	assert.Equal(f.Estimate(), h.Estimate())
+}
+
+// customModel is a motion model not known to the encoding.
+type customModel struct {
+	ConstantPosition
+}
+
+func TestEncodingInvalid(t *testing.T) {
+	assert := assert.New(t)
+	m, err := NewConstantPosition(&ProcessNoise{SX: 1.0, SY: 1.0, SZ: 1.0, ST: 1.0})
+	assert.NoError(err)
+	f, err := NewFilterWithModel(&customModel{*m})
+	assert.NoError(err)
+	_, err = f.MarshalBinary()
+	assert.Equal(ErrUnsupportedModel, err)
+	_, err = json.Marshal(f)
+	assert.Error(err)
+
+	var g Filter
+	assert.Equal(ErrInvalidEncoding, g.UnmarshalBinary([]byte("garbage")))
+	assert.Equal(ErrInvalidEncoding, g.UnmarshalJSON([]byte(`{"version":2,"model":{"order":1}}`)))
+	assert.Equal(ErrInvalidEncoding, g.UnmarshalJSON([]byte(`{"version":1,"model":{"order":3}}`)))
+	assert.Equal(ErrInvalidEncoding, g.UnmarshalJSON([]byte(`{"version":1,"model":{"order":1},"state":[1,2]}`)))
+	assert.Nil(g.model)
+
+	// The state is restored exactly, and the restored filter is independent of the original one.
+	f, err = NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0},
+		WithInitialState(mat.NewVecDense(_N, []float64{1, 2, 3, 4, 5, 6}), mat.NewDiagDense(_N, []float64{1, 2, 3, 4, 5, 6})))
+	assert.NoError(err)
+	data, err := f.MarshalBinary()
+	assert.NoError(err)
+	assert.NoError(g.UnmarshalBinary(data))
+	assert.Equal(f.state.RawVector().Data, g.state.RawVector().Data)
+	assert.True(mat.Equal(f.cov, g.cov))
+	assert.NoError(g.Predict(1.0))
+	assert.Equal(1.0, f.state.AtVec(_X))
+}
+
+func TestEncodingInvalidValues(t *testing.T) {
+	assert := assert.New(t)
+	conf := AdaptiveNoise{Forgetting: 0.95, MinQScale: 1.0, MaxQScale: 10.0, MinRScale: 1.0, MaxRScale: 10.0}
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, WithAdaptiveNoise(conf), WithDiagnostics(10))
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 3; i++ {
+		_, err = f.Observe(1.0, randomObserved(rnd, i))
+		assert.NoError(err)
+	}
+	for _, corrupt := range []func(d *filterData){
+		func(d *filterData) { d.Model.Q[1][0] = -1.0 },
+		func(d *filterData) { d.Model.Q[1][0] = math.NaN() },
+		func(d *filterData) { d.State[0] = math.Inf(1) },
+		func(d *filterData) { d.Cov[0] = math.NaN() },
+		func(d *filterData) { d.Cov[0] = -1.0 },
+		func(d *filterData) { d.Adaptation.QScale = 0.0 },
+		func(d *filterData) { d.Adaptation.RScale = math.NaN() },
+		func(d *filterData) { d.Adaptation.Q[0] = math.Inf(-1) },
+		func(d *filterData) { d.Adaptation.Steps = -1 },
+		func(d *filterData) { d.Diagnostics.Innovation[0].Value = math.NaN() },
+		func(d *filterData) { d.Diagnostics.Innovation[0].DOF = 0 },
+	} {
+		d, err := f.data()
+		assert.NoError(err)
+		corrupt(d)
+		var g Filter
+		assert.Equal(ErrInvalidEncoding, g.setData(d))
+		assert.Nil(g.model)
+	}
+
+	// The filter that diverged to NaN can't be serialized.
+	f.state.SetVec(_X, math.NaN())
+	_, err = f.MarshalBinary()
+	assert.Equal(ErrNotFinite, err)
+	_, err = f.MarshalJSON()
+	assert.Equal(ErrNotFinite, err)
+}
+
+func TestGeoFilterEncodingInvalidModel(t *testing.T) {
+	// The GeoFilter works with the constant velocity and constant acceleration models only.
+	assert := assert.New(t)
+	m, err := NewConstantPosition(&ProcessNoise{SX: 0.1, SY: 0.1, SZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	f, err := NewFilterWithModel(m)
+	assert.NoError(err)
+	data, err := f.MarshalBinary()
+	assert.NoError(err)
+	var g GeoFilter
+	assert.Equal(ErrInvalidEncoding, g.UnmarshalBinary(data))
+	data, err = f.MarshalJSON()
+	assert.NoError(err)
+	assert.Equal(ErrInvalidEncoding, g.UnmarshalJSON(data))
+	assert.Nil(g.filter)
+}
+
+func TestGeoFilterAccelerationRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:               43.0,
+		DistancePerSecond:     0.1,
+		SpeedPerSecond:        0.1,
+		AccelerationPerSecond: 0.5,
+	})
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              5.0,
+		SpeedAccuracy:      0.5,
+		Direction:          30.0,
+		DirectionAccuracy:  5.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   5.0,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+
+	data, err := f.MarshalBinary()
+	assert.NoError(err)
+	var g GeoFilter
+	assert.NoError(g.UnmarshalBinary(data))
+	assert.IsType(&ConstantAcceleration{}, g.filter.model)
+	data, err = json.Marshal(f)
+	assert.NoError(err)
+	var h GeoFilter
+	assert.NoError(json.Unmarshal(data, &h))
+	assert.IsType(&ConstantAcceleration{}, h.filter.model)
+	for i := 0; i < 5; i++ {
+		ob.Lat += 0.0001 * float64(i)
+		for _, x := range []*GeoFilter{f, &g, &h} {
+			_, err = x.Observe(1.0, ob)
+			assert.NoError(err)
+		}
+	}
+	assert.Equal(f.Estimate(), g.Estimate())
+	assert.Equal(f.Estimate(), h.Estimate())
+}
@@ -82,7 +82,7 @@ func main() {
 			}
 		}
 		// Observe the next data point.
-		err = filter.Observe(timeDelta, &point)
+		_, err = filter.Observe(timeDelta, &point)
 		// Sometimes the filter may return error, although this should not happen under any
 		// realistic curcumstances.
 		if err != nil {
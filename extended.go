@@ -0,0 +1,308 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/diff/fd"
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidMeasurement is returned when the nonlinear measurement has no values or no measurement function.
+var ErrInvalidMeasurement = fmt.Errorf("invalid nonlinear measurement")
+
+// TransitionFunc returns the state after time td, starting from the state x.
+type TransitionFunc func(x mat.Vector, td float64) *mat.VecDense
+
+// TransitionJacobianFunc returns the Jacobian of the transition function at the state x.
+type TransitionJacobianFunc func(x mat.Vector, td float64) *mat.Dense
+
+// ProcessNoiseFunc returns the process noise covariance accumulated over time td.
+type ProcessNoiseFunc func(td float64) mat.Matrix
+
+// MeasurementFunc returns the expected measurement for the state x.
+type MeasurementFunc func(x mat.Vector) *mat.VecDense
+
+// MeasurementJacobianFunc returns the Jacobian of the measurement function at the state x.
+type MeasurementJacobianFunc func(x mat.Vector) *mat.Dense
+
+// ResidualFunc returns the difference between the measurement z and the expected measurement hx,
+// for example, wrapping the angles to [-Pi, Pi].
+type ResidualFunc func(z, hx mat.Vector) *mat.VecDense
+
+// NonlinearModel describes how the state evolves over time.
+type NonlinearModel struct {
+	Transition         TransitionFunc         // State transition.
+	TransitionJacobian TransitionJacobianFunc // Optional, computed numerically if nil.
+	ProcessNoise       ProcessNoiseFunc       // Process noise covariance.
+}
+
+// NonlinearMeasurement is a single observation, z = h(x) + v, v ~ N(0, R).
+type NonlinearMeasurement struct {
+	Z        mat.Vector              // Observed values.
+	H        MeasurementFunc         // Measurement function.
+	Jacobian MeasurementJacobianFunc // Optional, computed numerically if nil.
+	R        mat.Matrix              // Measurement noise covariance.
+	Residual ResidualFunc            // Optional, z - h(x) if nil.
+}
+
+// NewNonlinearModel returns the motion model m as a nonlinear model, so that it can be used with
+// nonlinear measurements.
+func NewNonlinearModel(m MotionModel) *NonlinearModel {
+	n := m.Dim()
+	return &NonlinearModel{
+		Transition: func(x mat.Vector, td float64) *mat.VecDense {
+			f := mat.NewDense(n, n, nil)
+			m.Transition(f, td)
+			var r mat.VecDense
+			r.MulVec(f, x)
+			return &r
+		},
+		TransitionJacobian: func(x mat.Vector, td float64) *mat.Dense {
+			f := mat.NewDense(n, n, nil)
+			m.Transition(f, td)
+			return f
+		},
+		ProcessNoise: func(td float64) mat.Matrix {
+			q := mat.NewDense(n, n, nil)
+			m.ProcessNoise(q, td)
+			return q
+		},
+	}
+}
+
+// ExtendedFilter is an extended Kalman filter, which linearizes the nonlinear transition and
+// measurement functions around the current state.
+type ExtendedFilter struct {
+	gaussian
+	model *NonlinearModel
+}
+
+// NewExtendedFilter creates and returns a new extended Kalman filter with the initial state x and covariance p.
+// Only WithUpdateForm and WithSquareRoot apply, the other options return ErrUnsupportedOption.
+func NewExtendedFilter(m *NonlinearModel, x mat.Vector, p mat.Matrix, opts ...Option) (*ExtendedFilter, error) {
+	if m == nil || m.Transition == nil || m.ProcessNoise == nil {
+		return nil, ErrInvalidModel
+	}
+	if x == nil || !isSquare(p, x.Len()) {
+		return nil, ErrDimensions
+	}
+	o := newOptions(opts)
+	if !o.onlyUpdate() {
+		return nil, ErrUnsupportedOption
+	}
+	e := &ExtendedFilter{
+		gaussian: gaussian{opts: o},
+		model:    m,
+	}
+	e.reset(mat.VecDenseCopyOf(x), mat.DenseCopyOf(p))
+	return e, nil
+}
+
+// Predict advances the state by td without a measurement.
+func (e *ExtendedFilter) Predict(td float64) error {
+	n := e.state.Len()
+	x := e.model.Transition(e.state, td)
+	if x == nil || x.Len() != n {
+		return ErrDimensions
+	}
+	var f *mat.Dense
+	if e.model.TransitionJacobian != nil {
+		f = e.model.TransitionJacobian(e.state, td)
+	} else {
+		var ok bool
+		f, ok = numericJacobian(n, e.state, func(x mat.Vector) *mat.VecDense {
+			return e.model.Transition(x, td)
+		})
+		if !ok {
+			return ErrDimensions
+		}
+	}
+	q := e.model.ProcessNoise(td)
+	if !isSquare(f, n) || !isSquare(q, n) {
+		return ErrDimensions
+	}
+	// The covariance is propagated with the Jacobian, the state with the transition function.
+	e.predict(f, q)
+	e.state.CopyVec(x)
+	return nil
+}
+
+// Update corrects the state with the measurement.
+func (e *ExtendedFilter) Update(ob *NonlinearMeasurement) error {
+	if ob == nil || ob.Z == nil || ob.H == nil {
+		return ErrInvalidMeasurement
+	}
+	n := e.state.Len()
+	m := ob.Z.Len()
+	hx := ob.H(e.state)
+	if hx == nil || hx.Len() != m || !isSquare(ob.R, m) {
+		return ErrDimensions
+	}
+	var h *mat.Dense
+	if ob.Jacobian != nil {
+		h = ob.Jacobian(e.state)
+	} else {
+		var ok bool
+		if h, ok = numericJacobian(m, e.state, ob.H); !ok {
+			return ErrDimensions
+		}
+	}
+	if h == nil {
+		return ErrDimensions
+	}
+	if r, c := h.Dims(); r != m || c != n {
+		return ErrDimensions
+	}
+	// Linear update with the pseudo measurement z' = y + H*x, so that z' - H*x is the residual.
+	var z mat.VecDense
+	z.MulVec(h, e.state)
+	z.AddVec(&z, residual(ob, hx))
+	return e.correct(&z, h, ob.R)
+}
+
+// Observe advances the state by td and corrects it with the measurement.
+func (e *ExtendedFilter) Observe(td float64, ob *NonlinearMeasurement) error {
+	if err := e.Predict(td); err != nil {
+		return err
+	}
+	return e.Update(ob)
+}
+
+// State returns a copy of the current state.
+func (e *ExtendedFilter) State() *mat.VecDense {
+	return mat.VecDenseCopyOf(e.state)
+}
+
+// Covariance returns a copy of the current state covariance.
+func (e *ExtendedFilter) Covariance() *mat.Dense {
+	return mat.DenseCopyOf(e.cov)
+}
+
+// Estimate returns the current state estimate, assuming the motion model state layout.
+func (e *ExtendedFilter) Estimate() *Estimated {
+	return e.estimate()
+}
+
+// NewRangeBearingMeasurement returns a measurement of the range and bearing from the point (x0, y0)
+// to the position (X, Y). The bearing is in radians, from the X axis towards the Y axis, which is
+// the direction from North if X is the latitude and Y is the longitude.
+func NewRangeBearingMeasurement(x0, y0 float64, rng, bearing float64, rangeAccuracy, bearingAccuracy float64) *NonlinearMeasurement {
+	return &NonlinearMeasurement{
+		Z: mat.NewVecDense(2, []float64{rng, bearing}),
+		H: func(x mat.Vector) *mat.VecDense {
+			dx := x.AtVec(_X) - x0
+			dy := x.AtVec(_Y) - y0
+			return mat.NewVecDense(2, []float64{math.Hypot(dx, dy), math.Atan2(dy, dx)})
+		},
+		Jacobian: func(x mat.Vector) *mat.Dense {
+			dx := x.AtVec(_X) - x0
+			dy := x.AtVec(_Y) - y0
+			r2 := dx*dx + dy*dy
+			r := math.Sqrt(r2)
+			h := mat.NewDense(2, x.Len(), nil)
+			if r2 == 0 {
+				return h
+			}
+			h.Set(0, _X, dx/r)
+			h.Set(0, _Y, dy/r)
+			h.Set(1, _X, -dy/r2)
+			h.Set(1, _Y, dx/r2)
+			return h
+		},
+		R:        mat.NewDiagDense(2, []float64{rangeAccuracy * rangeAccuracy, bearingAccuracy * bearingAccuracy}),
+		Residual: angleResidual(1),
+	}
+}
+
+// NewSpeedHeadingMeasurement returns a measurement of the speed and heading, the heading is in radians,
+// from the X axis towards the Y axis. The state must have the speed components.
+func NewSpeedHeadingMeasurement(speed, heading float64, speedAccuracy, headingAccuracy float64) *NonlinearMeasurement {
+	return &NonlinearMeasurement{
+		Z: mat.NewVecDense(2, []float64{speed, heading}),
+		H: func(x mat.Vector) *mat.VecDense {
+			vx := x.AtVec(_VX)
+			vy := x.AtVec(_VY)
+			return mat.NewVecDense(2, []float64{math.Hypot(vx, vy), math.Atan2(vy, vx)})
+		},
+		Jacobian: func(x mat.Vector) *mat.Dense {
+			vx := x.AtVec(_VX)
+			vy := x.AtVec(_VY)
+			s2 := vx*vx + vy*vy
+			s := math.Sqrt(s2)
+			h := mat.NewDense(2, x.Len(), nil)
+			if s2 == 0 {
+				return h
+			}
+			h.Set(0, _VX, vx/s)
+			h.Set(0, _VY, vy/s)
+			h.Set(1, _VX, -vy/s2)
+			h.Set(1, _VY, vx/s2)
+			return h
+		},
+		R:        mat.NewDiagDense(2, []float64{speedAccuracy * speedAccuracy, headingAccuracy * headingAccuracy}),
+		Residual: angleResidual(1),
+	}
+}
+
+// angleResidual returns the residual function that wraps the given components to [-Pi, Pi].
+func angleResidual(angles ...int) ResidualFunc {
+	return func(z, hx mat.Vector) *mat.VecDense {
+		var y mat.VecDense
+		y.SubVec(z, hx)
+		for _, i := range angles {
+			y.SetVec(i, wrapAngle(y.AtVec(i)))
+		}
+		return &y
+	}
+}
+
+// wrapAngle returns the angle wrapped to [-Pi, Pi].
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
+
+// residual returns z - h(x) for the measurement.
+func residual(ob *NonlinearMeasurement, hx mat.Vector) *mat.VecDense {
+	return subtract(ob.Residual, ob.Z, hx)
+}
+
+// subtract returns a - b, computed with fn if it's not nil.
+func subtract(fn ResidualFunc, a, b mat.Vector) *mat.VecDense {
+	if fn != nil {
+		return fn(a, b)
+	}
+	var y mat.VecDense
+	y.SubVec(a, b)
+	return &y
+}
+
+// numericJacobian returns the m by n Jacobian of fn at x, computed with central differences.
+// It returns false if fn doesn't return m values.
+func numericJacobian(m int, x mat.Vector, fn func(x mat.Vector) *mat.VecDense) (*mat.Dense, bool) {
+	n := x.Len()
+	jac := mat.NewDense(m, n, nil)
+	x0 := make([]float64, n)
+	for i := range x0 {
+		x0[i] = x.AtVec(i)
+	}
+	ok := true
+	fd.Jacobian(jac, func(y, x []float64) {
+		r := fn(mat.NewVecDense(len(x), x))
+		if r == nil || r.Len() != len(y) {
+			ok = false
+			for i := range y {
+				y[i] = math.NaN()
+			}
+			return
+		}
+		for i := range y {
+			y[i] = r.AtVec(i)
+		}
+	}, x0, &fd.JacobianSettings{Formula: fd.Central})
+	return jac, ok
+}
@@ -0,0 +1,198 @@
+package kalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestExtendedMatchesFilter(t *testing.T) {
+	// With linear functions, the extended filter is the regular filter.
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  1.0,
+		SZ:  1.0,
+		SVX: 0.1,
+		SVY: 0.1,
+		SVZ: 0.1,
+		ST:  1.0}
+	cv, err := NewConstantVelocity(d)
+	assert.NoError(err)
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	ob := &Observed{
+		X:          1.0,
+		Y:          2.0,
+		Z:          3.0,
+		XA:         1.0,
+		YA:         2.0,
+		ZA:         3.0,
+		VXA:        10.0,
+		VYA:        10.0,
+		VZA:        10.0,
+		Components: ComponentAll,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+
+	analytic, err := NewExtendedFilter(NewNonlinearModel(cv), f.state, f.cov)
+	assert.NoError(err)
+	m := NewNonlinearModel(cv)
+	m.TransitionJacobian = nil
+	numeric, err := NewExtendedFilter(m, f.state, f.cov)
+	assert.NoError(err)
+
+	h := mat.NewDense(3, _N, nil)
+	h.Set(0, _X, 1.0)
+	h.Set(1, _Y, 1.0)
+	h.Set(2, _Z, 1.0)
+	for i := 0; i < 10; i++ {
+		ob.X = float64(i)
+		ob.Y = 2.0 * float64(i)
+		ob.Z = -float64(i)
+		ob.Components = ComponentPosition
+		_, err = f.Observe(1.0, ob)
+		assert.NoError(err)
+		nm := &NonlinearMeasurement{
+			Z: mat.NewVecDense(3, []float64{ob.X, ob.Y, ob.Z}),
+			H: func(x mat.Vector) *mat.VecDense {
+				var r mat.VecDense
+				r.MulVec(h, x)
+				return &r
+			},
+			R: mat.NewDiagDense(3, []float64{1.0, 4.0, 9.0}),
+		}
+		assert.NoError(analytic.Observe(1.0, nm))
+		assert.NoError(numeric.Observe(1.0, nm))
+	}
+	assert.True(mat.EqualApprox(f.state, analytic.State(), 1e-9))
+	assert.True(mat.EqualApprox(f.cov, analytic.Covariance(), 1e-9))
+	assert.True(mat.EqualApprox(f.state, numeric.State(), 1e-6))
+	assert.True(mat.EqualApprox(f.cov, numeric.Covariance(), 1e-6))
+}
+
+func TestExtendedRangeBearing(t *testing.T) {
+	// Locate a stationary target from range and bearing measured by two beacons.
+	assert := assert.New(t)
+	cp, err := NewConstantPosition(&ProcessNoise{})
+	assert.NoError(err)
+	x := mat.NewVecDense(3, []float64{40.0, 60.0, 0.0})
+	p := mat.NewDiagDense(3, []float64{100.0, 100.0, 1.0})
+	e, err := NewExtendedFilter(NewNonlinearModel(cp), x, p)
+	assert.NoError(err)
+
+	target := [2]float64{30.0, 70.0}
+	beacons := [][2]float64{{0.0, 0.0}, {100.0, 0.0}}
+	for i := 0; i < 10; i++ {
+		for _, b := range beacons {
+			dx := target[0] - b[0]
+			dy := target[1] - b[1]
+			ob := NewRangeBearingMeasurement(b[0], b[1], math.Hypot(dx, dy), math.Atan2(dy, dx), 1.0, 0.01)
+			assert.NoError(e.Observe(0.0, ob))
+		}
+	}
+	est := e.Estimate()
+	assert.InDelta(30.0, est.X, 0.1)
+	assert.InDelta(70.0, est.Y, 0.1)
+	assert.True(est.XA < 1.0)
+}
+
+func TestExtendedSpeedHeading(t *testing.T) {
+	// Heading close to Pi must not be confused by the wrap-around.
+	assert := assert.New(t)
+	cv, err := NewConstantVelocity(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	x := mat.NewVecDense(6, []float64{0.0, 0.0, 0.0, -10.0, -0.1, 0.0})
+	p := mat.NewDiagDense(6, []float64{1.0, 1.0, 1.0, 1.0, 1.0, 1.0})
+	e, err := NewExtendedFilter(NewNonlinearModel(cv), x, p)
+	assert.NoError(err)
+	heading := math.Pi - 0.01
+	for i := 0; i < 20; i++ {
+		assert.NoError(e.Observe(1.0, NewSpeedHeadingMeasurement(10.0, heading, 0.1, 0.01)))
+	}
+	est := e.Estimate()
+	assert.InDelta(10.0*math.Cos(heading), est.VX, 0.05)
+	assert.InDelta(10.0*math.Sin(heading), est.VY, 0.05)
+}
+
+func TestNumericJacobian(t *testing.T) {
+	assert := assert.New(t)
+	ob := NewRangeBearingMeasurement(1.0, 2.0, 0.0, 0.0, 1.0, 1.0)
+	x := mat.NewVecDense(3, []float64{4.0, 6.0, 0.0})
+	jac, ok := numericJacobian(2, x, ob.H)
+	assert.True(ok)
+	assert.True(mat.EqualApprox(ob.Jacobian(x), jac, 1e-6))
+}
+
+func TestRangeBearingAtOrigin(t *testing.T) {
+	// At the reference point the Jacobian is zero, and the update doesn't produce NaN.
+	assert := assert.New(t)
+	ob := NewRangeBearingMeasurement(1.0, 2.0, 0.0, 0.0, 1.0, 0.1)
+	x := mat.NewVecDense(6, []float64{1.0, 2.0, 0.0, 0.0, 0.0, 0.0})
+	assert.True(mat.Equal(mat.NewDense(2, 6, nil), ob.Jacobian(x)))
+	cv, err := NewConstantVelocity(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	e, err := NewExtendedFilter(NewNonlinearModel(cv), x, eye(6))
+	assert.NoError(err)
+	assert.NoError(e.Update(ob))
+	for _, v := range e.Covariance().RawMatrix().Data {
+		assert.False(math.IsNaN(v))
+	}
+	assert.False(math.IsNaN(e.State().AtVec(_X)))
+}
+
+func TestWrapAngle(t *testing.T) {
+	assert := assert.New(t)
+	assert.InDelta(0.0, wrapAngle(2*math.Pi), 1e-12)
+	assert.InDelta(-0.02, wrapAngle(2*math.Pi-0.02), 1e-12)
+	assert.InDelta(0.02, wrapAngle(-2*math.Pi+0.02), 1e-12)
+	assert.InDelta(-math.Pi+0.1, wrapAngle(math.Pi+0.1), 1e-12)
+}
+
+func TestExtendedInvalid(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewExtendedFilter(nil, mat.NewVecDense(1, nil), mat.NewDense(1, 1, nil))
+	assert.Equal(ErrInvalidModel, err)
+	cv, err := NewConstantVelocity(&ProcessNoise{})
+	assert.NoError(err)
+	_, err = NewExtendedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), mat.NewDense(3, 3, nil))
+	assert.Equal(ErrDimensions, err)
+	_, err = NewExtendedFilter(NewNonlinearModel(cv), nil, eye(6))
+	assert.Equal(ErrDimensions, err)
+	_, err = NewExtendedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), nil)
+	assert.Equal(ErrDimensions, err)
+	for _, opt := range []Option{
+		WithInnovationGate(0.99, RejectOutliers),
+		WithDiagnostics(10),
+		WithAdaptiveNoise(AdaptiveNoise{Forgetting: 0.95, MinQScale: 1.0, MaxQScale: 10.0, MinRScale: 1.0, MaxRScale: 10.0}),
+		WithInitialState(mat.NewVecDense(6, nil), eye(6)),
+		WithDiffusePrior(100.0),
+		WithFixedSize(),
+	} {
+		_, err = NewExtendedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(6), opt)
+		assert.Equal(ErrUnsupportedOption, err)
+	}
+	_, err = NewExtendedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(6), WithUpdateForm(SimpleForm), WithSquareRoot())
+	assert.NoError(err)
+
+	// The functions returning the wrong number of values, without the Jacobians.
+	short := func(x mat.Vector) *mat.VecDense { return mat.NewVecDense(1, nil) }
+	m := &NonlinearModel{
+		Transition:   func(x mat.Vector, td float64) *mat.VecDense { return short(x) },
+		ProcessNoise: func(td float64) mat.Matrix { return mat.NewDense(6, 6, nil) },
+	}
+	e, err := NewExtendedFilter(m, mat.NewVecDense(6, nil), eye(6))
+	assert.NoError(err)
+	assert.Equal(ErrDimensions, e.Predict(1.0))
+	e, err = NewExtendedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(6))
+	assert.NoError(err)
+	ob := &NonlinearMeasurement{Z: mat.NewVecDense(2, nil), H: short, R: eye(2)}
+	assert.Equal(ErrDimensions, e.Update(ob))
+	ob.H = nil
+	assert.Equal(ErrInvalidMeasurement, e.Update(ob))
+	assert.Equal(ErrInvalidMeasurement, e.Update(nil))
+	assert.Equal(mat.NewVecDense(6, nil), e.State())
+}
@@ -4,12 +4,14 @@ package kalman
 
 import (
 	"fmt"
+	"math"
+	"time"
 
 	"gonum.org/v1/gonum/mat"
 )
 
 const (
-	_N = 6 // Size of the matrixes and vectors.
+	_N = 6 // Number of observable components, size of the constant velocity state.
 
 	// Symbolic names for rows/columns.
 	_X  = 0
@@ -23,130 +25,452 @@ const (
 // ErrInvalidProcNoise is returned when we can't compute process noise.
 var ErrInvalidProcNoise = fmt.Errorf("invalid process noise arguments")
 
+// ErrNotInitialized is returned when the filter has no state to work with yet.
+var ErrNotInitialized = fmt.Errorf("filter is not initialized")
+
+// ErrInvalidModel is returned when the motion model can't be used.
+var ErrInvalidModel = fmt.Errorf("invalid motion model")
+
+// ErrInvalidPrior is returned when the initial state can't be used.
+var ErrInvalidPrior = fmt.Errorf("invalid initial state")
+
 // Filter is a Kalman filter.
+//
+// Observe and Predict reuse the storage preallocated by the filter, so they don't allocate, unless
+// the filter is created with WithSquareRoot or WithAdaptiveNoise, or the observation has components
+// that the motion model doesn't have. With WithFixedSize, the computations use fixed-size arrays.
 type Filter struct {
-	state     mat.Vector // State.
-	cov       mat.Matrix // Covariance.
-	procNoise mat.Matrix // Process noise.
+	gaussian
+	model MotionModel  // Motion model.
+	diag  *Diagnostics // Consistency statistics, nil unless enabled with WithDiagnostics.
+	adapt *adaptation  // Noise estimation, nil unless enabled with WithAdaptiveNoise.
+	last  time.Time    // Time of the last update, zero if unknown.
+	work  *workspace   // Preallocated storage for the steps.
+	arith arithmetic   // Computations of the steps, the workspace unless WithFixedSize.
 }
 
 // ProcessNoise represents process noise.
+//
+// The process is modeled as a continuous random walk of the coordinates and of the speed.
+// SX and SVX are the standard deviations of the coordinate and speed steps accumulated over
+// time ST, so SVX*SVX/ST is the spectral density of the white noise acceleration.
 type ProcessNoise struct {
 	SX, SY, SZ    float64 // Random step (coordinates).
 	SVX, SVY, SVZ float64 // Random step (speed).
+	SAX, SAY, SAZ float64 // Random step (acceleration), only used by the constant acceleration model.
 	ST            float64 // Random step (time).
 }
 
 // Observed represents a single observation.
 type Observed struct {
-	X, Y, Z       float64 // Coordinates.
-	VX, VY, VZ    float64 // Speed.
-	XA, YA, ZA    float64 // Accuracy (coordinates).
-	VXA, VYA, VZA float64 // Accuracy (speed).
-}
-
-// NewFilter creates and returns a new Kalman filter.
-func NewFilter(d *ProcessNoise) (*Filter, error) {
-	if d.ST == 0 && (d.SX > 0 || d.SY > 0 || d.SZ > 0 || d.SVX > 0 || d.SVY > 0 || d.SVZ > 0) {
-		return nil, ErrInvalidProcNoise
-	}
-	// Init process noise.
-	procNoise := mat.NewDense(_N, _N, nil)
-	if d.ST > 0 {
-		procNoise.Set(_X, _X, d.SX*d.SX/d.ST)
-		procNoise.Set(_Y, _Y, d.SY*d.SY/d.ST)
-		procNoise.Set(_Z, _Z, d.SZ*d.SZ/d.ST)
-		procNoise.Set(_VX, _VX, d.SVX*d.SVX/d.ST)
-		procNoise.Set(_VY, _VY, d.SVY*d.SVY/d.ST)
-		procNoise.Set(_VZ, _VZ, d.SVZ*d.SVZ/d.ST)
-	}
-	return &Filter{procNoise: procNoise}, nil
-}
-
-func (f *Filter) initCov(ob *Observed) {
-	cov := mat.NewDense(_N, _N, nil)
-	cov.Set(_X, _X, ob.XA*ob.XA)
-	cov.Set(_Y, _Y, ob.YA*ob.YA)
-	cov.Set(_Z, _Z, ob.ZA*ob.ZA)
-	cov.Set(_VX, _VX, ob.VXA*ob.VXA)
-	cov.Set(_VY, _VY, ob.VYA*ob.VYA)
-	cov.Set(_VZ, _VZ, ob.VZA*ob.VZA)
-	f.cov = cov
-}
-
-func (f *Filter) predictState(td float64) mat.Vector {
-	m := mat.DenseCopyOf(eye(_N))
-	m.Set(_X, _VX, td)
-	m.Set(_Y, _VY, td)
-	m.Set(_Z, _VZ, td)
-
-	newState := mat.NewVecDense(6, nil)
-	newState.MulVec(m, f.state)
-	return newState
+	X, Y, Z       float64   // Coordinates.
+	VX, VY, VZ    float64   // Speed.
+	XA, YA, ZA    float64   // Accuracy (coordinates).
+	VXA, VYA, VZA float64   // Accuracy (speed).
+	Components    Component // Observed components, zero means all of them.
 }
 
-func (f *Filter) predictCov(td float64) mat.Matrix {
-	m := mat.DenseCopyOf(eye(6))
-	m.Set(_X, _VX, td)
-	m.Set(_Y, _VY, td)
-	m.Set(_Z, _VZ, td)
+// CorrelatedObserved represents a single observation with the full measurement noise covariance,
+// for the measurement errors that are correlated between the components.
+type CorrelatedObserved struct {
+	X, Y, Z    float64    // Coordinates.
+	VX, VY, VZ float64    // Speed.
+	Cov        mat.Matrix // Measurement noise covariance of the observed components, in X, Y, Z, VX, VY, VZ order.
+	Components Component  // Observed components, zero means all of them.
+}
 
-	var w mat.Dense
-	w.Scale(td, f.procNoise)
-	var r mat.Dense
-	r.Mul(f.cov, m.T())
-	r.Add(&r, &w)
-	return &r
+// Estimated contains the estimated state, obtained by processing several observations.
+type Estimated struct {
+	X, Y, Z       float64    // Coordinates.
+	VX, VY, VZ    float64    // Speed.
+	XA, YA, ZA    float64    // Accuracy (coordinates), standard deviation.
+	VXA, VYA, VZA float64    // Accuracy (speed), standard deviation.
+	AX, AY, AZ    float64    // Acceleration, zero if the model doesn't have it.
+	AXA, AYA, AZA float64    // Accuracy (acceleration), standard deviation.
+	QScale        float64    // Process noise scale, 1 unless adapted, see AdaptiveNoise.
+	RScale        float64    // Measurement noise scale, 1 unless adapted, see AdaptiveNoise.
+	Cov           *mat.Dense // Full state covariance.
 }
 
-func (f *Filter) kalmanGain(predCov mat.Matrix, ob *Observed) (mat.Matrix, error) {
-	r := mat.NewDense(_N, _N, nil)
-	r.Set(_X, _X, ob.XA*ob.XA)
-	r.Set(_Y, _Y, ob.YA*ob.YA)
-	r.Set(_Z, _Z, ob.ZA*ob.ZA)
-	r.Set(_VX, _VX, ob.VXA*ob.VXA)
-	r.Set(_VY, _VY, ob.VYA*ob.VYA)
-	r.Set(_VZ, _VZ, ob.VZA*ob.VZA)
-	var t mat.Dense
-	t.Add(predCov, r)
-	var it mat.Dense
-	err := it.Inverse(&t)
+// NewFilter creates and returns a new Kalman filter with the constant velocity motion model.
+func NewFilter(d *ProcessNoise, opts ...Option) (*Filter, error) {
+	m, err := NewConstantVelocity(d)
 	if err != nil {
 		return nil, err
 	}
-	var q mat.Dense
-	q.Mul(predCov, &it)
-	return &q, nil
+	return NewFilterWithModel(m, opts...)
+}
+
+// NewFilterWithModel creates and returns a new Kalman filter with the given motion model.
+func NewFilterWithModel(m MotionModel, opts ...Option) (*Filter, error) {
+	if m == nil || m.Dim() <= 0 {
+		return nil, ErrInvalidModel
+	}
+	o := newOptions(opts)
+	if o.gate != nil && !o.gate.valid() {
+		return nil, ErrInvalidGate
+	}
+	f := &Filter{gaussian: gaussian{opts: o}, model: m, work: newWorkspace(m.Dim())}
+	f.arith = f.work
+	if o.fixedSize {
+		if m.Dim() != _N || o.squareRoot {
+			return nil, ErrInvalidFixedSize
+		}
+		f.arith = newFixed(f.work)
+	}
+	if o.diagnostics {
+		if o.window <= 0 {
+			return nil, ErrInvalidWindow
+		}
+		f.diag = newDiagnostics(o.window)
+	}
+	if o.adaptive != nil {
+		if !o.adaptive.valid() {
+			return nil, ErrInvalidAdaptiveNoise
+		}
+		f.adapt = newAdaptation(*o.adaptive, m.Dim())
+	}
+	switch {
+	case o.initState != nil || o.initCov != nil:
+		if err := f.Init(o.initState, o.initCov); err != nil {
+			return nil, err
+		}
+	case o.diffusePrior && (!(o.diffuse > 0.0) || math.IsInf(o.diffuse, 1)):
+		return nil, ErrInvalidPrior
+	case o.diffusePrior:
+		n := m.Dim()
+		cov := mat.NewDense(n, n, nil)
+		for i := 0; i < n; i++ {
+			cov.Set(i, i, o.diffuse)
+		}
+		f.reset(mat.NewVecDense(n, nil), cov)
+	}
+	return f, nil
+}
+
+// Init sets the state and covariance, the following observations update them. The time of the
+// last update becomes unknown. The filter doesn't keep references to x and p. The state must be
+// finite and the covariance positive semi-definite, otherwise ErrInvalidPrior is returned.
+func (f *Filter) Init(x mat.Vector, p mat.Matrix) error {
+	n := f.model.Dim()
+	if x == nil || x.Len() != n || !isSquare(p, n) {
+		return ErrDimensions
+	}
+	state := mat.VecDenseCopyOf(x)
+	cov := mat.DenseCopyOf(p)
+	symmetrize(cov)
+	if !finite(state.RawVector().Data) || !isPSD(cov) {
+		return ErrInvalidPrior
+	}
+	f.reset(state, cov)
+	f.last = time.Time{}
+	if f.adapt != nil {
+		f.adapt.reset()
+	}
+	return nil
+}
+
+// clone returns a copy of the filter with the same model, options and state, which can be
+// updated without changing f. The copy doesn't record diagnostics.
+func (f *Filter) clone() *Filter {
+	c := &Filter{gaussian: gaussian{opts: f.opts}, model: f.model, last: f.last, work: newWorkspace(f.model.Dim())}
+	c.arith = c.work
+	if f.opts.fixedSize {
+		c.arith = newFixed(c.work)
+	}
+	if f.state != nil {
+		c.gaussian = f.snapshot()
+		c.opts = f.opts
+	}
+	if f.adapt != nil {
+		c.adapt = f.adapt.copy()
+	}
+	return c
+}
+
+// initState initializes the state and covariance from the first measurement.
+// Components missing from the measurement start at zero with a large variance.
+func (f *Filter) initState(m *measurement) {
+	n := f.model.Dim()
+	m = m.restrict(n)
+	state := mat.NewVecDense(n, nil)
+	cov := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		cov.Set(i, i, unobservedVariance)
+	}
+	for j, i := range m.idx {
+		state.SetVec(i, m.z.AtVec(j))
+		for l, k := range m.idx {
+			cov.Set(i, k, m.r.At(j, l))
+		}
+	}
+	f.reset(state, cov)
+}
+
+// transition returns the state transition matrix for time td.
+func (f *Filter) transition(td float64) *mat.Dense {
+	n := f.model.Dim()
+	m := mat.NewDense(n, n, nil)
+	f.model.Transition(m, td)
+	return m
+}
+
+// processNoiseCov returns the process noise covariance accumulated over time td,
+// multiplied by the adaptive scale.
+func (f *Filter) processNoiseCov(td float64) *mat.Dense {
+	n := f.model.Dim()
+	q := mat.NewDense(n, n, nil)
+	f.model.ProcessNoise(q, td)
+	if f.adapt != nil {
+		q.Scale(f.adapt.qScale, q)
+	}
+	return q
+}
+
+func (f *Filter) predictState(td float64) *mat.VecDense {
+	newState := mat.NewVecDense(f.model.Dim(), nil)
+	newState.MulVec(f.transition(td), f.state)
+	return newState
+}
+
+// predictCov returns F*P*F^T + Q(td).
+func (f *Filter) predictCov(td float64) *mat.Dense {
+	m := f.transition(td)
+	var r mat.Dense
+	r.Mul(m, f.cov)
+	r.Mul(&r, m.T())
+	r.Add(&r, f.processNoiseCov(td))
+	return &r
+}
+
+// Predict advances the state and covariance by td without a measurement.
+func (f *Filter) Predict(td float64) error {
+	if f.state == nil {
+		return ErrNotInitialized
+	}
+	w := f.work
+	f.model.Transition(w.tr, td)
+	f.model.ProcessNoise(w.q, td)
+	if f.adapt != nil {
+		w.q.Scale(f.adapt.qScale, w.q)
+		f.adapt.accumulate(w.tr, w.q)
+	}
+	if f.opts.squareRoot {
+		f.predict(w.tr, w.q)
+	} else {
+		f.arith.predict(f.state, f.cov)
+	}
+	if !f.last.IsZero() {
+		f.last = f.last.Add(seconds(td))
+	}
+	return nil
 }
 
 // Observe processes a single act of observation, td is the time since last update.
-func (f *Filter) Observe(td float64, ob *Observed) error {
+// The result tells whether the observation passed the innovation gate, if there is one.
+func (f *Filter) Observe(td float64, ob *Observed) (Result, error) {
+	return f.observe(td, f.work.measurement(ob))
+}
+
+// ObserveCorrelated processes a single act of observation with the full measurement noise
+// covariance, td is the time since last update.
+func (f *Filter) ObserveCorrelated(td float64, ob *CorrelatedObserved) (Result, error) {
+	m, err := newCorrelatedMeasurement(ob)
+	if err != nil {
+		return Result{}, err
+	}
+	return f.observe(td, m)
+}
+
+// observe processes a single measurement, td is the time since last update.
+func (f *Filter) observe(td float64, m *measurement) (Result, error) {
 	if f.state == nil {
-		f.initCov(ob)
-		f.state = mat.NewVecDense(_N, []float64{ob.X, ob.Y, ob.Z, ob.VX, ob.VY, ob.VZ})
-		return nil
+		f.initState(m)
+		return accepted, nil
+	}
+
+	if err := f.Predict(td); err != nil {
+		return Result{}, err
 	}
+	return f.update(m)
+}
+
+// Innovation returns the innovation of the observation made td after the last update,
+// without changing the filter.
+func (f *Filter) Innovation(td float64, ob *Observed) (*Innovation, error) {
+	return f.innovation(td, newMeasurement(ob))
+}
 
-	predState := f.predictState(td)
-	predCov := f.predictCov(td)
-	k, err := f.kalmanGain(predCov, ob)
+// innovation returns the innovation of the measurement made td after the last update.
+func (f *Filter) innovation(td float64, m *measurement) (*Innovation, error) {
+	if f.state == nil {
+		return nil, ErrNotInitialized
+	}
+	n := f.model.Dim()
+	m = m.restrict(n)
+	if len(m.idx) == 0 {
+		return &Innovation{}, nil
+	}
+	y, s := innovation(f.predictState(td), f.predictCov(td), m.z, m.h(n), f.noise(m))
+	nis, err := mahalanobis(y, s)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &Innovation{Residual: y, Cov: s, NIS: nis, Components: m.components()}, nil
+}
 
-	obState := mat.NewVecDense(_N, []float64{ob.X, ob.Y, ob.Z, ob.VX, ob.VY, ob.VZ})
-	var stateDif mat.VecDense
-	stateDif.SubVec(obState, predState)
-	var r mat.VecDense
-	r.MulVec(k, &stateDif)
-	r.AddVec(&r, predState)
-	f.state = &r
+// noise returns the measurement noise covariance, multiplied by the adaptive scale.
+func (f *Filter) noise(m *measurement) *mat.Dense {
+	if f.adapt == nil {
+		return m.r
+	}
+	var r mat.Dense
+	r.Scale(f.adapt.rScale, m.r)
+	return &r
+}
 
-	cov := mat.DenseCopyOf(eye(_N))
-	cov.Sub(cov, k)
-	cov.Mul(cov, predCov)
-	f.cov = cov
-	return nil
+// update corrects the predicted state with the measurement, using only the observed components.
+// Measurements outside of the innovation gate are rejected or have their noise inflated.
+func (f *Filter) update(m *measurement) (Result, error) {
+	n := f.model.Dim()
+	m = m.restrict(n)
+	k := len(m.idx)
+	if k == 0 {
+		return accepted, nil
+	}
+	a := f.arith
+	r := f.noise(m)
+	factorized := a.factorize(f.state, f.cov, m, r, 1.0)
+	var nis float64
+	if factorized {
+		nis = sumSquares(a.whitened(k))
+	} else {
+		// Not positive definite, solved the slow way.
+		y, s := innovation(f.state, f.cov, m.z, m.h(n), r)
+		var err error
+		if nis, err = mahalanobis(y, s); err != nil {
+			return Result{}, err
+		}
+	}
+	res := Result{Status: Accepted, NIS: nis, Scale: 1.0}
+	g := f.opts.gate
+	if g != nil && nis > g.limits[k] {
+		if g.action == RejectOutliers {
+			res.Status = Rejected
+			return res, nil
+		}
+		res.Status = Inflated
+		res.Scale = nis / g.limits[k]
+	}
+	// Rejected measurements are not recorded, they don't affect the estimate.
+	if f.diag != nil {
+		var white []float64
+		if factorized {
+			white = a.whitened(k)
+		}
+		f.diag.addInnovation(nis, m, white)
+	}
+	if res.Status == Inflated {
+		factorized = a.factorize(f.state, f.cov, m, r, res.Scale)
+	}
+
+	var y *mat.VecDense
+	var hph *mat.Dense
+	if f.adapt != nil {
+		y, hph = innovation(f.state, f.cov, m.z, m.h(n), r)
+		hph.Sub(hph, r)
+	}
+	if f.opts.squareRoot || !factorized {
+		var scaled mat.Dense
+		scaled.Scale(res.Scale, r)
+		if err := f.correct(m.z, m.h(n), &scaled); err != nil {
+			return Result{}, err
+		}
+	} else {
+		a.correct(f.state, f.cov, m, r, res.Scale, f.opts.updateForm)
+	}
+	if f.adapt != nil {
+		// Outliers are not used to estimate the noise.
+		if res.Status == Accepted {
+			h := m.h(n)
+			eps, s1 := innovation(f.state, f.cov, m.z, h, r)
+			s1.Sub(s1, r)
+			f.adapt.adapt(y, hph, eps, s1, h, r)
+		}
+		f.adapt.reset()
+	}
+	return res, nil
+}
+
+// logLikelihood returns the log likelihood of the measurement for the predicted state.
+func (f *Filter) logLikelihood(m *measurement) (float64, error) {
+	n := f.model.Dim()
+	m = m.restrict(n)
+	if len(m.idx) == 0 {
+		return 0.0, nil
+	}
+	y, s := innovation(f.state, f.cov, m.z, m.h(n), f.noise(m))
+	var chol mat.Cholesky
+	if !chol.Factorize(symmetric(s)) {
+		return 0.0, ErrInvalidCovariance
+	}
+	k := float64(len(m.idx))
+	return -0.5 * (normalizedSquare(&chol, y) + chol.LogDet() + k*math.Log(2.0*math.Pi)), nil
+}
+
+// NEES returns the normalized estimation error squared of the current estimate for the ground
+// truth, using only the components present in it. The value is recorded in the diagnostics, if enabled.
+func (f *Filter) NEES(truth *Observed) (float64, error) {
+	if f.state == nil {
+		return 0.0, ErrNotInitialized
+	}
+	n := f.model.Dim()
+	m := newMeasurement(truth).restrict(n)
+	if len(m.idx) == 0 {
+		return 0.0, nil
+	}
+	h := m.h(n)
+	var e mat.VecDense
+	e.MulVec(h, f.state)
+	e.SubVec(m.z, &e)
+	var ph, p mat.Dense
+	ph.Mul(f.cov, h.T())
+	p.Mul(h, &ph)
+	nees, err := mahalanobis(&e, &p)
+	if err != nil {
+		return 0.0, err
+	}
+	if f.diag != nil {
+		f.diag.addNEES(nees, len(m.idx))
+	}
+	return nees, nil
+}
+
+// Diagnostics returns the consistency statistics, or nil if they are not enabled with WithDiagnostics.
+func (f *Filter) Diagnostics() *Diagnostics {
+	return f.diag
+}
+
+// Initialized returns true if the filter has processed at least one observation.
+func (f *Filter) Initialized() bool {
+	return f.state != nil
+}
+
+// Estimate returns the current state estimate, or nil if the filter is not initialized.
+func (f *Filter) Estimate() *Estimated {
+	if f.state == nil {
+		return nil
+	}
+	return f.scaled(f.estimate())
+}
+
+// scaled sets the adaptive noise scales of the estimate, if the noise is adapted.
+func (f *Filter) scaled(e *Estimated) *Estimated {
+	if f.adapt != nil {
+		e.QScale = f.adapt.qScale
+		e.RScale = f.adapt.rScale
+	}
+	return e
 }
 
 // eye returns an n by n identity matrix.
@@ -3,9 +3,11 @@ package kalman
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
 )
 
 func TestInvalidProcNoise(t *testing.T) {
@@ -33,17 +35,20 @@ func TestImproveAccuracy(t *testing.T) {
 		VZA: 0.01,
 	}
 	// First observation.
-	assert.NoError(f.Observe(0.0, ob))
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
 	// After a single observation, the error should be the same as in the observation.
 
 	// Second observation.
-	assert.NoError(f.Observe(0.0, ob))
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
 	assert.InDelta(0.5, f.cov.At(_X, _X), 0.1)
 	assert.InDelta(0.5, f.cov.At(_Y, _Y), 0.1)
 	assert.InDelta(0.5, f.cov.At(_Z, _Z), 0.1)
 
 	// Third observation.
-	assert.NoError(f.Observe(0.0, ob))
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
 	assert.InDelta(1.0/3.0, f.cov.At(_X, _X), 0.1)
 	assert.InDelta(1.0/3.0, f.cov.At(_Y, _Y), 0.1)
 	assert.InDelta(1.0/3.0, f.cov.At(_Z, _Z), 0.1)
@@ -65,7 +70,8 @@ func TestPointInBetween(t *testing.T) {
 		VYA: 0.01,
 		VZA: 0.01,
 	}
-	assert.NoError(f.Observe(0.0, ob1))
+	_, err = f.Observe(0.0, ob1)
+	assert.NoError(err)
 
 	ob2 := &Observed{
 		X:   20.0,
@@ -78,7 +84,8 @@ func TestPointInBetween(t *testing.T) {
 		VYA: 0.01,
 		VZA: 0.01,
 	}
-	assert.NoError(f.Observe(0.0, ob2))
+	_, err = f.Observe(0.0, ob2)
+	assert.NoError(err)
 	assert.InDelta(15.0, f.state.AtVec(_X), 0.1)
 	assert.InDelta(15.0, f.state.AtVec(_Y), 0.1)
 	assert.InDelta(15.0, f.state.AtVec(_Z), 0.1)
@@ -103,20 +110,23 @@ func TestImproveAccuracyWhileMoving(t *testing.T) {
 		VZA: 0.01,
 	}
 	// First observation.
-	assert.NoError(f.Observe(0.0, ob))
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
 	// After a single observation, the error should be the same as in the observation.
 	assert.InDelta(10.0, f.state.AtVec(_X), 0.1)
 	assert.InDelta(1.0, f.cov.At(_X, _X), 0.1)
 
 	// Second observation.
 	ob.X = 11.0
-	assert.NoError(f.Observe(1.0, ob))
+	_, err = f.Observe(1.0, ob)
+	assert.NoError(err)
 	assert.InDelta(11.0, f.state.AtVec(_X), 0.1)
 	assert.InDelta(0.5, f.cov.At(_X, _X), 0.1)
 
 	// Third observation.
 	ob.X = 12.0
-	assert.NoError(f.Observe(1.0, ob))
+	_, err = f.Observe(1.0, ob)
+	assert.NoError(err)
 	assert.InDelta(12.0, f.state.AtVec(_X), 0.1)
 	assert.InDelta(1.0/3.0, f.cov.At(_X, _X), 0.1)
 }
@@ -139,7 +149,8 @@ func TestGoodPrecisionPoorPrecision(t *testing.T) {
 		VYA: 0.01,
 		VZA: 0.01,
 	}
-	assert.NoError(f.Observe(0.0, goodPrecision))
+	_, err = f.Observe(0.0, goodPrecision)
+	assert.NoError(err)
 
 	poorPrecision := &Observed{
 		X:   20.0,
@@ -152,7 +163,8 @@ func TestGoodPrecisionPoorPrecision(t *testing.T) {
 		VYA: 0.01,
 		VZA: 0.01,
 	}
-	assert.NoError(f.Observe(0.0, poorPrecision))
+	_, err = f.Observe(0.0, poorPrecision)
+	assert.NoError(err)
 
 	assert.InDelta(10.38, f.state.AtVec(_X), 0.01)
 	assert.InDelta(10.1, f.state.AtVec(_Y), 0.01)
@@ -176,7 +188,8 @@ func TestConvergeOnLocation(t *testing.T) {
 		VYA: 0.01,
 		VZA: 0.01,
 	}
-	assert.NoError(f.Observe(0.0, ob0))
+	_, err = f.Observe(0.0, ob0)
+	assert.NoError(err)
 
 	// TODO: Use converge()
 	converged := false
@@ -192,7 +205,8 @@ func TestConvergeOnLocation(t *testing.T) {
 			VYA: 0.01,
 			VZA: 0.01,
 		}
-		assert.NoError(f.Observe(1.0, ob1))
+		_, err = f.Observe(1.0, ob1)
+		assert.NoError(err)
 		if math.Abs(f.state.AtVec(_X)-50.0) < 1.0 {
 			converged = true
 			break
@@ -252,12 +266,12 @@ func TestConvergeFasterWithMoreNoise(t *testing.T) {
 }
 
 func converge(filter *Filter, loc0, loc1 *Observed, td float64, distance float64, maxIter int) (int, error) {
-	if err := filter.Observe(0.0, loc0); err != nil {
+	if _, err := filter.Observe(0.0, loc0); err != nil {
 		return 0, err
 	}
 
 	for i := 0; i < maxIter; i++ {
-		if err := filter.Observe(td, loc1); err != nil {
+		if _, err := filter.Observe(td, loc1); err != nil {
 			return 0, err
 		}
 		dx := filter.state.AtVec(_X) - loc1.X
@@ -270,3 +284,649 @@ func converge(filter *Filter, loc0, loc1 *Observed, td float64, distance float64
 	}
 	return maxIter, fmt.Errorf("max iteration reached")
 }
+
+func TestEstimate(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	assert.False(f.Initialized())
+	assert.Nil(f.Estimate())
+
+	ob := &Observed{
+		X:   10.0,
+		Y:   20.0,
+		Z:   30.0,
+		VX:  1.0,
+		VY:  2.0,
+		VZ:  3.0,
+		XA:  1.0,
+		YA:  2.0,
+		ZA:  3.0,
+		VXA: 0.1,
+		VYA: 0.2,
+		VZA: 0.3,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+	assert.True(f.Initialized())
+
+	e := f.Estimate()
+	assert.NotNil(e)
+	assert.Equal(10.0, e.X)
+	assert.Equal(20.0, e.Y)
+	assert.Equal(30.0, e.Z)
+	assert.Equal(1.0, e.VX)
+	assert.Equal(2.0, e.VY)
+	assert.Equal(3.0, e.VZ)
+	assert.InDelta(1.0, e.XA, 1e-9)
+	assert.InDelta(2.0, e.YA, 1e-9)
+	assert.InDelta(3.0, e.ZA, 1e-9)
+	assert.InDelta(0.1, e.VXA, 1e-9)
+	assert.InDelta(0.2, e.VYA, 1e-9)
+	assert.InDelta(0.3, e.VZA, 1e-9)
+	r, c := e.Cov.Dims()
+	assert.Equal(_N, r)
+	assert.Equal(_N, c)
+	assert.InDelta(4.0, e.Cov.At(_Y, _Y), 1e-9)
+
+	// The returned covariance is a copy.
+	e.Cov.Set(_X, _X, 100.0)
+	assert.InDelta(1.0, f.cov.At(_X, _X), 1e-9)
+}
+
+func TestPredict(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{
+		SX: 1.0,
+		SY: 1.0,
+		SZ: 1.0,
+		ST: 1.0})
+	assert.NoError(err)
+	assert.Equal(ErrNotInitialized, f.Predict(1.0))
+
+	ob := &Observed{
+		X:   10.0,
+		Y:   10.0,
+		Z:   10.0,
+		VX:  1.0,
+		VY:  -1.0,
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.01,
+		VYA: 0.01,
+		VZA: 0.01,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+
+	// Without a measurement, the position moves with the speed and uncertainty grows.
+	assert.NoError(f.Predict(2.0))
+	e := f.Estimate()
+	assert.InDelta(12.0, e.X, 1e-9)
+	assert.InDelta(8.0, e.Y, 1e-9)
+	assert.InDelta(10.0, e.Z, 1e-9)
+	assert.InDelta(1.0, e.VX, 1e-9)
+	assert.True(e.XA > 1.0)
+	assert.True(e.ZA > 1.0)
+}
+
+func TestPredictCovClosedForm(t *testing.T) {
+	// Check the predicted covariance against the closed form of a coordinate and speed random walk.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{
+		SX:  2.0,
+		SY:  1.0,
+		SZ:  0.5,
+		SVX: 0.3,
+		SVY: 0.2,
+		SVZ: 0.1,
+		ST:  2.0})
+	assert.NoError(err)
+	_, err = f.Observe(0.0, &Observed{
+		XA:  1.0,
+		YA:  2.0,
+		ZA:  3.0,
+		VXA: 0.5,
+		VYA: 0.6,
+		VZA: 0.7,
+	})
+	assert.NoError(err)
+
+	td := 7.0
+	cov := f.predictCov(td)
+	axes := []struct {
+		p, v       int
+		sp, sv     float64
+		pvar, vvar float64
+	}{
+		{_X, _VX, 2.0, 0.3, 1.0, 0.25},
+		{_Y, _VY, 1.0, 0.2, 4.0, 0.36},
+		{_Z, _VZ, 0.5, 0.1, 9.0, 0.49},
+	}
+	for _, a := range axes {
+		qp := a.sp * a.sp / 2.0
+		qv := a.sv * a.sv / 2.0
+		assert.InDelta(a.pvar+td*td*a.vvar+qp*td+qv*td*td*td/3.0, cov.At(a.p, a.p), 1e-9)
+		assert.InDelta(td*a.vvar+qv*td*td/2.0, cov.At(a.p, a.v), 1e-9)
+		assert.InDelta(td*a.vvar+qv*td*td/2.0, cov.At(a.v, a.p), 1e-9)
+		assert.InDelta(a.vvar+qv*td, cov.At(a.v, a.v), 1e-9)
+	}
+	// Axes are independent.
+	assert.Equal(0.0, cov.At(_X, _Y))
+	assert.Equal(0.0, cov.At(_X, _VY))
+	assert.Equal(0.0, cov.At(_VX, _VZ))
+}
+
+func TestPredictCovLongGap(t *testing.T) {
+	// A single long prediction must match many short ones, the noise model is exact.
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  1.0,
+		SZ:  1.0,
+		SVX: 0.5,
+		SVY: 0.5,
+		SVZ: 0.5,
+		ST:  1.0}
+	ob := &Observed{
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.1,
+		VYA: 0.1,
+		VZA: 0.1,
+	}
+	f1, err := NewFilter(d)
+	assert.NoError(err)
+	_, err = f1.Observe(0.0, ob)
+	assert.NoError(err)
+	assert.NoError(f1.Predict(100.0))
+
+	f2, err := NewFilter(d)
+	assert.NoError(err)
+	_, err = f2.Observe(0.0, ob)
+	assert.NoError(err)
+	for i := 0; i < 1000; i++ {
+		assert.NoError(f2.Predict(0.1))
+	}
+
+	for i := 0; i < _N; i++ {
+		for j := 0; j < _N; j++ {
+			assert.InDelta(f1.cov.At(i, j), f2.cov.At(i, j), 1e-6*math.Max(1.0, math.Abs(f1.cov.At(i, j))))
+		}
+	}
+	// Speed uncertainty dominates the coordinate uncertainty on long gaps.
+	assert.InDelta(1.0+100.0*100.0*0.01+100.0+0.25*100.0*100.0*100.0/3.0, f1.cov.At(_X, _X), 1e-6)
+}
+
+func TestPositionOnly(t *testing.T) {
+	// Speed is not observed, but the filter infers it from the changing position.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{
+		SVX: 0.1,
+		SVY: 0.1,
+		SVZ: 0.1,
+		ST:  1.0})
+	assert.NoError(err)
+	for i := 0; i < 20; i++ {
+		_, err = f.Observe(1.0, &Observed{
+			X:          2.0 * float64(i),
+			Y:          10.0,
+			Z:          -1.0 * float64(i),
+			XA:         0.5,
+			YA:         0.5,
+			ZA:         0.5,
+			VX:         100.0, // Not observed, must be ignored.
+			Components: ComponentPosition,
+		})
+		assert.NoError(err)
+	}
+	e := f.Estimate()
+	assert.InDelta(38.0, e.X, 0.5)
+	assert.InDelta(10.0, e.Y, 0.5)
+	assert.InDelta(-19.0, e.Z, 0.5)
+	assert.InDelta(2.0, e.VX, 0.1)
+	assert.InDelta(0.0, e.VY, 0.1)
+	assert.InDelta(-1.0, e.VZ, 0.1)
+}
+
+func TestVelocityOnly(t *testing.T) {
+	// Observing the speed only does not change the coordinates beyond the motion.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	_, err = f.Observe(0.0, &Observed{
+		X:   10.0,
+		Y:   10.0,
+		Z:   10.0,
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 10.0,
+		VYA: 10.0,
+		VZA: 10.0,
+	})
+	assert.NoError(err)
+	_, err = f.Observe(0.0, &Observed{
+		VX:         3.0,
+		VXA:        0.01,
+		VYA:        0.01,
+		VZA:        0.01,
+		Components: ComponentVelocity,
+	})
+	assert.NoError(err)
+	e := f.Estimate()
+	assert.InDelta(10.0, e.X, 1e-9)
+	assert.InDelta(1.0, e.XA, 1e-9)
+	assert.InDelta(3.0, e.VX, 0.01)
+	assert.InDelta(0.01, e.VXA, 0.001)
+}
+
+func TestSingleAxis(t *testing.T) {
+	// An altitude-only observation only affects the altitude.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	ob := &Observed{
+		X:   10.0,
+		Y:   10.0,
+		Z:   10.0,
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.01,
+		VYA: 0.01,
+		VZA: 0.01,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+	_, err = f.Observe(0.0, &Observed{
+		X:          50.0, // Not observed, must be ignored.
+		Z:          20.0,
+		ZA:         1.0,
+		Components: ComponentZ,
+	})
+	assert.NoError(err)
+	e := f.Estimate()
+	assert.InDelta(10.0, e.X, 1e-9)
+	assert.InDelta(10.0, e.Y, 1e-9)
+	assert.InDelta(15.0, e.Z, 1e-9)
+	assert.InDelta(1.0, e.XA, 1e-9)
+	assert.InDelta(math.Sqrt(0.5), e.ZA, 1e-9)
+}
+
+func TestPartialFirstObservation(t *testing.T) {
+	// Components missing from the first observation start with a large uncertainty.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	_, err = f.Observe(0.0, &Observed{
+		X:          1.0,
+		Y:          2.0,
+		Z:          3.0,
+		VX:         4.0,
+		XA:         1.0,
+		YA:         1.0,
+		ZA:         1.0,
+		Components: ComponentPosition,
+	})
+	assert.NoError(err)
+	e := f.Estimate()
+	assert.Equal(1.0, e.X)
+	assert.Equal(0.0, e.VX)
+	assert.Equal(1.0, e.XA)
+	assert.Equal(math.Sqrt(unobservedVariance), e.VXA)
+}
+
+func TestUpdateFormsAgree(t *testing.T) {
+	// In exact arithmetic, both forms give the same covariance.
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  1.0,
+		SZ:  1.0,
+		SVX: 0.1,
+		SVY: 0.1,
+		SVZ: 0.1,
+		ST:  1.0}
+	joseph, err := NewFilter(d)
+	assert.NoError(err)
+	simple, err := NewFilter(d, WithUpdateForm(SimpleForm))
+	assert.NoError(err)
+	for i := 0; i < 10; i++ {
+		ob := &Observed{
+			X:   float64(i),
+			Y:   float64(i),
+			Z:   float64(i),
+			XA:  2.0,
+			YA:  3.0,
+			ZA:  4.0,
+			VXA: 0.5,
+			VYA: 0.5,
+			VZA: 0.5,
+		}
+		_, err = joseph.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = simple.Observe(1.0, ob)
+		assert.NoError(err)
+	}
+	assert.True(mat.EqualApprox(joseph.state, simple.state, 1e-9))
+	assert.True(mat.EqualApprox(joseph.cov, simple.cov, 1e-9))
+}
+
+func TestLongRunPositiveDefinite(t *testing.T) {
+	// Many updates with very accurate measurements must keep the covariance symmetric and positive definite.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{
+		SX:  1e-6,
+		SY:  1e-6,
+		SZ:  1e-6,
+		SVX: 1e-6,
+		SVY: 1e-6,
+		SVZ: 1e-6,
+		ST:  1.0})
+	assert.NoError(err)
+	for i := 0; i < 100000; i++ {
+		ob := &Observed{
+			X:   float64(i % 7),
+			Y:   float64(i % 11),
+			Z:   float64(i % 13),
+			XA:  1e-6,
+			YA:  1e-6,
+			ZA:  1e-6,
+			VXA: 1e-7,
+			VYA: 1e-7,
+			VZA: 1e-7,
+		}
+		if i%3 == 0 {
+			ob.Components = ComponentPosition
+		}
+		if _, err := f.Observe(0.1, ob); err != nil {
+			assert.NoError(err)
+			break
+		}
+	}
+	assert.True(mat.Equal(f.cov, f.cov.T()))
+	var chol mat.Cholesky
+	assert.True(chol.Factorize(mat.NewSymDense(_N, f.cov.RawMatrix().Data)))
+	e := f.Estimate()
+	for _, a := range []float64{e.XA, e.YA, e.ZA, e.VXA, e.VYA, e.VZA} {
+		assert.False(math.IsNaN(a))
+		assert.True(a > 0.0)
+	}
+}
+
+func TestObserveCorrelated(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+		X:          1.0,
+		Y:          2.0,
+		Cov:        mat.NewDense(2, 2, []float64{4.0, 1.0, 1.0, 4.0}),
+		Components: ComponentX | ComponentY,
+	})
+	assert.NoError(err)
+	// The first observation initializes the covariance.
+	assert.Equal(1.0, f.state.AtVec(_X))
+	assert.Equal(2.0, f.state.AtVec(_Y))
+	assert.Equal(1.0, f.cov.At(_X, _Y))
+	assert.Equal(unobservedVariance, f.cov.At(_Z, _Z))
+
+	// With no time passed, the update is the product of the two gaussians.
+	r := mat.NewDense(2, 2, []float64{4.0, 3.6, 3.6, 4.0})
+	_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+		X:          3.0,
+		Y:          1.0,
+		Cov:        r,
+		Components: ComponentX | ComponentY,
+	})
+	assert.NoError(err)
+	var pi, ri, p mat.Dense
+	assert.NoError(pi.Inverse(mat.NewDense(2, 2, []float64{4.0, 1.0, 1.0, 4.0})))
+	assert.NoError(ri.Inverse(r))
+	pi.Add(&pi, &ri)
+	assert.NoError(p.Inverse(&pi))
+	var x, b mat.VecDense
+	b.MulVec(&ri, mat.NewVecDense(2, []float64{2.0, -1.0}))
+	x.MulVec(&p, &b)
+	assert.InDelta(1.0+x.AtVec(0), f.state.AtVec(_X), 1e-9)
+	assert.InDelta(2.0+x.AtVec(1), f.state.AtVec(_Y), 1e-9)
+	assert.True(mat.EqualApprox(&p, f.cov.Slice(_X, _Z, _X, _Z), 1e-9))
+}
+
+func TestObserveCorrelatedDiagonal(t *testing.T) {
+	// With a diagonal covariance, the observation is the same as with the accuracies.
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	f1, err := NewFilter(d)
+	assert.NoError(err)
+	f2, err := NewFilter(d)
+	assert.NoError(err)
+	for i := 0; i < 5; i++ {
+		ob := &Observed{
+			X:          float64(i),
+			Z:          1.0,
+			VX:         1.0,
+			XA:         2.0,
+			ZA:         3.0,
+			VXA:        0.5,
+			Components: ComponentX | ComponentZ | ComponentVX,
+		}
+		_, err = f1.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = f2.ObserveCorrelated(1.0, &CorrelatedObserved{
+			X:          ob.X,
+			Z:          ob.Z,
+			VX:         ob.VX,
+			Cov:        mat.NewDiagDense(3, []float64{4.0, 9.0, 0.25}),
+			Components: ob.Components,
+		})
+		assert.NoError(err)
+	}
+	assert.True(mat.EqualApprox(f1.state, f2.state, 1e-12))
+	assert.True(mat.EqualApprox(f1.cov, f2.cov, 1e-12))
+}
+
+func TestObserveCorrelatedInvalid(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+		Cov:        mat.NewDense(2, 2, nil),
+		Components: ComponentPosition,
+	})
+	assert.Equal(ErrDimensions, err)
+	_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+		Components: ComponentPosition,
+	})
+	assert.Equal(ErrDimensions, err)
+	_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+		Cov:        mat.NewDense(2, 2, []float64{1.0, 0.5, 0.4, 1.0}),
+		Components: ComponentX | ComponentY,
+	})
+	assert.Equal(ErrInvalidMeasurementNoise, err)
+	// Symmetric, but indefinite or not finite.
+	for _, cov := range [][]float64{{1.0, 2.0, 2.0, 1.0}, {-1.0, 0.0, 0.0, 1.0}, {math.NaN(), 0.0, 0.0, 1.0}, {math.Inf(1), 0.0, 0.0, 1.0}} {
+		_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+			Cov:        mat.NewDense(2, 2, cov),
+			Components: ComponentX | ComponentY,
+		})
+		assert.Equal(ErrInvalidMeasurementNoise, err, "%v", cov)
+	}
+	assert.False(f.Initialized())
+
+	// Singular is fine, the observation is exact along one direction.
+	_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+		Cov:        mat.NewDense(2, 2, []float64{1.0, 1.0, 1.0, 1.0}),
+		Components: ComponentX | ComponentY,
+	})
+	assert.NoError(err)
+}
+
+func TestObserveCorrelatedRounding(t *testing.T) {
+	// J*C*J^T is symmetric only up to rounding errors, and is accepted.
+	assert := assert.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	asymmetric := 0
+	for i := 0; i < 200; i++ {
+		j := mat.NewDense(3, 3, nil)
+		j.Apply(func(_, _ int, _ float64) float64 { return rnd.NormFloat64() }, j)
+		c := mat.NewDiagDense(3, []float64{rnd.Float64(), rnd.Float64(), rnd.Float64()})
+		var jc, cov mat.Dense
+		jc.Mul(j, c)
+		cov.Mul(&jc, j.T())
+		if !mat.Equal(&cov, cov.T()) {
+			asymmetric++
+		}
+		m, err := newCorrelatedMeasurement(&CorrelatedObserved{Cov: &cov, Components: ComponentPosition})
+		assert.NoError(err)
+		if err == nil {
+			assert.True(mat.Equal(m.r, m.r.T()))
+		}
+	}
+	assert.True(asymmetric > 0)
+}
+
+func TestInitialState(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	x := mat.NewVecDense(_N, []float64{1.0, 2.0, 3.0, 1.0, 0.0, 0.0})
+	p := mat.NewDense(_N, _N, nil)
+	for i := 0; i < _N; i++ {
+		p.Set(i, i, 100.0)
+	}
+	f, err := NewFilter(d, WithInitialState(x, p))
+	assert.NoError(err)
+	assert.True(f.Initialized())
+	x.SetVec(_X, 10.0)
+	assert.Equal(1.0, f.Estimate().X)
+	assert.Equal(10.0, f.Estimate().XA)
+
+	// The first observation updates the prior, instead of replacing it.
+	_, err = f.Observe(1.0, &Observed{
+		X:          4.0,
+		Y:          2.0,
+		Z:          3.0,
+		XA:         10.0,
+		YA:         10.0,
+		ZA:         10.0,
+		Components: ComponentPosition,
+	})
+	assert.NoError(err)
+	e := f.Estimate()
+	assert.True(e.X > 2.0 && e.X < 4.0, "%f", e.X)
+	assert.True(e.VX > 1.0, "%f", e.VX)
+	assert.True(e.XA < 10.0)
+}
+
+func TestDiffusePrior(t *testing.T) {
+	// With a diffuse prior, the first observation is about the same as the bootstrap from it.
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	f, err := NewFilter(d, WithDiffusePrior(1e8))
+	assert.NoError(err)
+	assert.True(f.Initialized())
+	g, err := NewFilter(d)
+	assert.NoError(err)
+	ob := &Observed{
+		X:  1.0,
+		Y:  2.0,
+		Z:  3.0,
+		XA: 1.0,
+		YA: 2.0,
+		ZA: 3.0,
+	}
+	for i := 0; i < 5; i++ {
+		_, err = f.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = g.Observe(1.0, ob)
+		assert.NoError(err)
+	}
+	assert.InDelta(g.Estimate().X, f.Estimate().X, 1e-6)
+	assert.InDelta(g.Estimate().YA, f.Estimate().YA, 1e-3)
+	assert.InDelta(g.Estimate().ZA, f.Estimate().ZA, 1e-3)
+}
+
+func TestInitialStateInvalid(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	_, err := NewFilter(d, WithInitialState(mat.NewVecDense(3, nil), mat.NewDense(_N, _N, nil)))
+	assert.Equal(ErrDimensions, err)
+	_, err = NewFilter(d, WithInitialState(mat.NewVecDense(_N, nil), nil))
+	assert.Equal(ErrDimensions, err)
+	for _, variance := range []float64{-1.0, 0.0, math.NaN(), math.Inf(1)} {
+		_, err = NewFilter(d, WithDiffusePrior(variance))
+		assert.Equal(ErrInvalidPrior, err, "%v", variance)
+	}
+
+	// The last prior option wins.
+	f, err := NewFilter(d, WithInitialState(mat.NewVecDense(3, nil), nil), WithDiffusePrior(1.0))
+	assert.NoError(err)
+	assert.Equal(1.0, f.Estimate().XA)
+	f, err = NewFilter(d)
+	assert.NoError(err)
+	assert.Equal(ErrDimensions, f.Init(mat.NewVecDense(_N, nil), mat.NewDense(3, 3, nil)))
+	assert.False(f.Initialized())
+
+	// Not finite or indefinite.
+	indefinite := mat.DenseCopyOf(eye(_N))
+	indefinite.Set(0, 0, -1.0)
+	nan := mat.DenseCopyOf(eye(_N))
+	nan.Set(1, 1, math.NaN())
+	inf := mat.DenseCopyOf(eye(_N))
+	inf.Set(2, 2, math.Inf(1))
+	for _, p := range []*mat.Dense{indefinite, nan, inf} {
+		assert.Equal(ErrInvalidPrior, f.Init(mat.NewVecDense(_N, nil), p))
+	}
+	assert.Equal(ErrInvalidPrior, f.Init(mat.NewVecDense(_N, []float64{math.NaN(), 0, 0, 0, 0, 0}), eye(_N)))
+	_, err = NewFilter(d, WithSquareRoot(), WithInitialState(mat.NewVecDense(_N, nil), indefinite))
+	assert.Equal(ErrInvalidPrior, err)
+	assert.False(f.Initialized())
+}
+
+func TestObserveAllocations(t *testing.T) {
+	// The steady state steps reuse the workspace.
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	for _, opts := range [][]Option{
+		nil,
+		{WithUpdateForm(SimpleForm)},
+		{WithInnovationGate(0.99, InflateOutliers)},
+		{WithDiagnostics(10)},
+	} {
+		f, err := NewFilter(d, opts...)
+		assert.NoError(err)
+		ob := &Observed{X: 1.0, Y: 2.0, Z: 3.0, XA: 1.0, YA: 1.0, ZA: 1.0, VXA: 0.1, VYA: 0.1, VZA: 0.1}
+		for i := 0; i < 20; i++ {
+			_, err = f.Observe(1.0, ob)
+			assert.NoError(err)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			ob.X += 1.0
+			_, _ = f.Observe(1.0, ob)
+			ob.Components = ComponentPosition
+			_, _ = f.Observe(1.0, ob)
+			ob.Components = 0
+		})
+		assert.Equal(0.0, allocs)
+	}
+}
+
+func BenchmarkFilterObserve(b *testing.B) {
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ob := &Observed{X: 1.0, Y: 2.0, Z: 3.0, XA: 1.0, YA: 1.0, ZA: 1.0, VXA: 0.1, VYA: 0.1, VZA: 0.1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.X = float64(i)
+		if _, err := f.Observe(1.0, ob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
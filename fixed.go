@@ -0,0 +1,246 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidFixedSize is returned when the fixed-size arithmetic can't be used with the filter.
+var ErrInvalidFixedSize = fmt.Errorf("fixed-size arithmetic requires a state of size 6 and no square root")
+
+type (
+	vec6 [_N]float64
+	mat6 [_N]vec6
+)
+
+// fixed does the computations of the filter steps for the state of size 6 on fixed-size arrays,
+// with the multiplications unrolled. The state and covariance are copied in and out of the arrays.
+type fixed struct {
+	w    *workspace // Transition and process noise.
+	p    mat6       // Covariance.
+	t    mat6       // Transition, and then temporary results.
+	l    mat6       // Cholesky factor of the innovation covariance, lower.
+	k    mat6       // Kalman gain, 6 by m.
+	y, e vec6       // Innovation and whitened innovation.
+}
+
+// newFixed returns the fixed-size arithmetic using the transition and process noise of w.
+func newFixed(w *workspace) *fixed {
+	return &fixed{w: w}
+}
+
+// predict sets x to F*x and p to F*P*F^T + Q.
+func (f *fixed) predict(x *mat.VecDense, p *mat.Dense) {
+	load6(&f.t, f.w.tr)
+	load6(&f.p, p)
+	var v vec6
+	for i := 0; i < _N; i++ {
+		v[i] = x.AtVec(i)
+	}
+	for i := 0; i < _N; i++ {
+		t := &f.t[i]
+		x.SetVec(i, t[0]*v[0]+t[1]*v[1]+t[2]*v[2]+t[3]*v[3]+t[4]*v[4]+t[5]*v[5])
+	}
+	var fp mat6
+	mul6(&fp, &f.t, &f.p)
+	mulT6(&f.p, &fp, &f.t)
+	q := f.w.q.RawMatrix()
+	for i := 0; i < _N; i++ {
+		for j := 0; j < _N; j++ {
+			f.p[i][j] += q.Data[i*q.Stride+j]
+		}
+	}
+	symmetrize6(&f.p)
+	store6(p, &f.p)
+}
+
+// factorize computes the innovation y = z - H*x, the Cholesky factor L of its covariance
+// S = H*P*H^T + scale*R and the whitened innovation L^-1*y. It returns false if S is not positive definite.
+func (f *fixed) factorize(x *mat.VecDense, p *mat.Dense, m *measurement, r *mat.Dense, scale float64) bool {
+	load6(&f.p, p)
+	k := len(m.idx)
+	for j, i := range m.idx {
+		f.y[j] = m.z.AtVec(j) - x.AtVec(i)
+		for l, c := range m.idx[:j+1] {
+			f.l[j][l] = f.p[i][c] + scale*r.At(j, l)
+		}
+	}
+	if !cholesky6(&f.l, k) {
+		return false
+	}
+	forward6(&f.l, k, &f.e, &f.y)
+	return true
+}
+
+// whitened returns the whitened innovation of the factorized measurement with m components.
+func (f *fixed) whitened(m int) []float64 {
+	return f.e[:m]
+}
+
+// correct updates x and p with the measurement factorized for them, r is scaled by scale.
+func (f *fixed) correct(x *mat.VecDense, p *mat.Dense, m *measurement, r *mat.Dense, scale float64, form UpdateForm) {
+	k := len(m.idx)
+
+	// K = P*H^T*S^-1, row by row: S*K[i]^T = (P*H^T)[i]^T.
+	for i := 0; i < _N; i++ {
+		var ph vec6
+		for j, c := range m.idx {
+			ph[j] = f.p[i][c]
+		}
+		forward6(&f.l, k, &f.k[i], &ph)
+		backward6(&f.l, k, &f.k[i], &f.k[i])
+	}
+
+	// x = x + K*y.
+	for i := 0; i < _N; i++ {
+		ki := &f.k[i]
+		var v float64
+		for j := 0; j < k; j++ {
+			v += ki[j] * f.y[j]
+		}
+		x.SetVec(i, x.AtVec(i)+v)
+	}
+
+	// A = (I-K*H)*P = P - K*(H*P).
+	a := &f.t
+	var hp mat6
+	for j, s := range m.idx {
+		hp[j] = f.p[s]
+	}
+	for i := 0; i < _N; i++ {
+		ki := &f.k[i]
+		for c := 0; c < _N; c++ {
+			v := f.p[i][c]
+			for j := 0; j < k; j++ {
+				v -= ki[j] * hp[j][c]
+			}
+			a[i][c] = v
+		}
+	}
+	if form == SimpleForm {
+		f.p = *a
+		symmetrize6(&f.p)
+		store6(p, &f.p)
+		return
+	}
+
+	// Joseph form, (I-K*H)*P*(I-K*H)^T + K*R*K^T = A - A*H^T*K^T + K*R*K^T.
+	var c mat6 // K*R - A*H^T, 6 by m.
+	for i := 0; i < _N; i++ {
+		ki := &f.k[i]
+		for l := 0; l < k; l++ {
+			var v float64
+			for j := 0; j < k; j++ {
+				v += ki[j] * r.At(j, l)
+			}
+			c[i][l] = scale*v - a[i][m.idx[l]]
+		}
+	}
+	mulT6(&f.p, &c, &f.k)
+	for i := 0; i < _N; i++ {
+		for j := 0; j < _N; j++ {
+			f.p[i][j] += a[i][j]
+		}
+	}
+	symmetrize6(&f.p)
+	store6(p, &f.p)
+}
+
+// load6 copies the 6 by 6 matrix m to dst.
+func load6(dst *mat6, m *mat.Dense) {
+	raw := m.RawMatrix()
+	for i := 0; i < _N; i++ {
+		copy(dst[i][:], raw.Data[i*raw.Stride:i*raw.Stride+_N])
+	}
+}
+
+// store6 copies a to the 6 by 6 matrix dst.
+func store6(dst *mat.Dense, a *mat6) {
+	raw := dst.RawMatrix()
+	for i := 0; i < _N; i++ {
+		copy(raw.Data[i*raw.Stride:i*raw.Stride+_N], a[i][:])
+	}
+}
+
+// mul6 sets dst to a*b.
+func mul6(dst, a, b *mat6) {
+	for i := 0; i < _N; i++ {
+		ai := &a[i]
+		for j := 0; j < _N; j++ {
+			dst[i][j] = ai[0]*b[0][j] + ai[1]*b[1][j] + ai[2]*b[2][j] + ai[3]*b[3][j] + ai[4]*b[4][j] + ai[5]*b[5][j]
+		}
+	}
+}
+
+// mulT6 sets dst to a*b^T.
+func mulT6(dst, a, b *mat6) {
+	for i := 0; i < _N; i++ {
+		ai := &a[i]
+		for j := 0; j < _N; j++ {
+			bj := &b[j]
+			dst[i][j] = ai[0]*bj[0] + ai[1]*bj[1] + ai[2]*bj[2] + ai[3]*bj[3] + ai[4]*bj[4] + ai[5]*bj[5]
+		}
+	}
+}
+
+// symmetrize6 sets a to (A + A^T)/2.
+func symmetrize6(a *mat6) {
+	for i := 0; i < _N; i++ {
+		for j := i + 1; j < _N; j++ {
+			v := (a[i][j] + a[j][i]) / 2.0
+			a[i][j] = v
+			a[j][i] = v
+		}
+	}
+}
+
+// cholesky6 replaces the lower triangle of the m by m top left block of a with its Cholesky factor L,
+// a = L*L^T. It returns false if the block is not positive definite.
+func cholesky6(a *mat6, m int) bool {
+	for j := 0; j < m; j++ {
+		aj := &a[j]
+		d := aj[j]
+		for k := 0; k < j; k++ {
+			d -= aj[k] * aj[k]
+		}
+		if !(d > 0.0) {
+			return false
+		}
+		d = math.Sqrt(d)
+		aj[j] = d
+		for i := j + 1; i < m; i++ {
+			ai := &a[i]
+			v := ai[j]
+			for k := 0; k < j; k++ {
+				v -= ai[k] * aj[k]
+			}
+			ai[j] = v / d
+		}
+	}
+	return true
+}
+
+// forward6 sets the first m elements of dst to L^-1*b, dst and b may be the same.
+func forward6(l *mat6, m int, dst, b *vec6) {
+	for i := 0; i < m; i++ {
+		li := &l[i]
+		v := b[i]
+		for k := 0; k < i; k++ {
+			v -= li[k] * dst[k]
+		}
+		dst[i] = v / li[i]
+	}
+}
+
+// backward6 sets the first m elements of dst to L^-T*b, dst and b may be the same.
+func backward6(l *mat6, m int, dst, b *vec6) {
+	for i := m - 1; i >= 0; i-- {
+		v := b[i]
+		for k := i + 1; k < m; k++ {
+			v -= l[k][i] * dst[k]
+		}
+		dst[i] = v / l[i][i]
+	}
+}
@@ -0,0 +1,215 @@
+package kalman
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestFixedCorrect(t *testing.T) {
+	// The fixed-size update is the same as the workspace one.
+	assert := assert.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	for _, form := range []UpdateForm{JosephForm, SimpleForm} {
+		for _, components := range []Component{ComponentAll, ComponentPosition, ComponentY | ComponentVX | ComponentVZ} {
+			var a mat.Dense
+			a.Apply(func(i, j int, v float64) float64 { return rnd.NormFloat64() }, mat.NewDense(_N, _N, nil))
+			p := mat.NewDense(_N, _N, nil)
+			p.Mul(&a, a.T())
+			x := mat.NewVecDense(_N, nil)
+			for i := 0; i < _N; i++ {
+				x.SetVec(i, rnd.NormFloat64())
+			}
+			m := newMeasurement(&Observed{
+				X: 1.0, Y: 2.0, Z: 3.0, VX: 4.0, VY: 5.0, VZ: 6.0,
+				XA: 1.0, YA: 2.0, ZA: 3.0, VXA: 0.5, VYA: 0.5, VZA: 0.5,
+				Components: components,
+			})
+			if len(m.idx) > 1 {
+				m.r.Set(0, 1, 0.3)
+				m.r.Set(1, 0, 0.3)
+			}
+
+			w := newWorkspace(_N)
+			f := newFixed(w)
+			x1, p1 := mat.VecDenseCopyOf(x), mat.DenseCopyOf(p)
+			assert.True(w.factorize(x1, p1, m, m.r, 2.0))
+			assert.True(f.factorize(x, p, m, m.r, 2.0))
+			assert.InDeltaSlice(w.whitened(len(m.idx)), f.whitened(len(m.idx)), 1e-9)
+			w.correct(x1, p1, m, m.r, 2.0, form)
+			f.correct(x, p, m, m.r, 2.0, form)
+			assert.True(mat.EqualApprox(x1, x, 1e-9))
+			assert.True(mat.EqualApprox(p1, p, 1e-9))
+		}
+	}
+}
+
+func TestFixedPredict(t *testing.T) {
+	assert := assert.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	w := newWorkspace(_N)
+	w.tr.Apply(func(i, j int, v float64) float64 { return rnd.NormFloat64() }, w.tr)
+	w.q.Apply(func(i, j int, v float64) float64 { return rnd.NormFloat64() }, w.q)
+	w.q.Mul(w.q, w.q.T())
+	p := mat.NewDense(_N, _N, nil)
+	p.Apply(func(i, j int, v float64) float64 { return rnd.NormFloat64() }, p)
+	p.Mul(p, p.T())
+	x := mat.NewVecDense(_N, nil)
+	for i := 0; i < _N; i++ {
+		x.SetVec(i, rnd.NormFloat64())
+	}
+	x1, p1 := mat.VecDenseCopyOf(x), mat.DenseCopyOf(p)
+	w.predict(x1, p1)
+	newFixed(w).predict(x, p)
+	assert.True(mat.EqualApprox(x1, x, 1e-9))
+	assert.True(mat.EqualApprox(p1, p, 1e-9))
+	// Both keep the covariance exactly symmetric.
+	assert.True(mat.Equal(p1, p1.T()))
+	assert.True(mat.Equal(p, p.T()))
+}
+
+func TestFixedMatchesFilter(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	for _, opts := range [][]Option{
+		nil,
+		{WithUpdateForm(SimpleForm)},
+		{WithInnovationGate(0.99, InflateOutliers)},
+		{WithAdaptiveNoise(AdaptiveNoise{Forgetting: 0.95, MinQScale: 1.0, MaxQScale: 100.0, MinRScale: 1.0, MaxRScale: 100.0})},
+	} {
+		f, err := NewFilter(d, opts...)
+		assert.NoError(err)
+		fast, err := NewFilter(d, append(opts, WithFixedSize())...)
+		assert.NoError(err)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 50; i++ {
+			ob := randomObserved(rnd, i)
+			if i%3 == 0 {
+				ob.Components = ComponentAll
+			}
+			res, err := f.Observe(0.5, ob)
+			assert.NoError(err)
+			fastRes, err := fast.Observe(0.5, ob)
+			assert.NoError(err)
+			assert.Equal(res.Status, fastRes.Status)
+			assert.InDelta(res.NIS, fastRes.NIS, 1e-9)
+		}
+		assert.True(mat.EqualApprox(f.state, fast.state, 1e-9))
+		assert.True(mat.EqualApprox(f.cov, fast.cov, 1e-9))
+	}
+}
+
+func TestGeoFixedMatchesFilter(t *testing.T) {
+	assert := assert.New(t)
+	d := &GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1}
+	g, err := NewGeoFilter(d)
+	assert.NoError(err)
+	fast, err := NewGeoFilter(d, WithFixedSize())
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		ob := &GeoObserved{
+			Lat:                43.0 + 0.0001*float64(i) + 0.00001*rnd.NormFloat64(),
+			Lng:                -71.0 + 0.00001*rnd.NormFloat64(),
+			Speed:              11.0 + rnd.NormFloat64(),
+			SpeedAccuracy:      1.0,
+			Direction:          5.0 * rnd.NormFloat64(),
+			DirectionAccuracy:  5.0,
+			HorizontalAccuracy: 10.0,
+			VerticalAccuracy:   5.0,
+		}
+		_, err = g.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = fast.Observe(1.0, ob)
+		assert.NoError(err)
+	}
+	e, fe := g.Estimate(), fast.Estimate()
+	assert.InDelta(e.Lat, fe.Lat, 1e-12)
+	assert.InDelta(e.Lng, fe.Lng, 1e-12)
+	assert.InDelta(e.Speed, fe.Speed, 1e-9)
+	assert.InDelta(e.HorizontalAccuracy, fe.HorizontalAccuracy, 1e-9)
+}
+
+func TestFixedAllocations(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, WithFixedSize())
+	assert.NoError(err)
+	ob := &Observed{X: 1.0, Y: 2.0, Z: 3.0, XA: 1.0, YA: 1.0, ZA: 1.0, VXA: 0.1, VYA: 0.1, VZA: 0.1}
+	_, err = f.Observe(1.0, ob)
+	assert.NoError(err)
+	allocs := testing.AllocsPerRun(100, func() {
+		ob.X += 1.0
+		_, _ = f.Observe(1.0, ob)
+	})
+	assert.Equal(0.0, allocs)
+}
+
+func TestFixedEncoding(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, WithFixedSize())
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	_, err = f.Observe(1.0, randomObserved(rnd, 0))
+	assert.NoError(err)
+	data, err := f.MarshalJSON()
+	assert.NoError(err)
+	var g Filter
+	assert.NoError(g.UnmarshalJSON(data))
+	assert.True(g.opts.fixedSize)
+	assert.IsType(&fixed{}, g.arith)
+	assert.Equal(f.Estimate(), g.Estimate())
+}
+
+func TestFixedInvalid(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	_, err := NewFilter(d, WithFixedSize(), WithSquareRoot())
+	assert.Equal(ErrInvalidFixedSize, err)
+	m, err := NewConstantAcceleration(d)
+	assert.NoError(err)
+	_, err = NewFilterWithModel(m, WithFixedSize())
+	assert.Equal(ErrInvalidFixedSize, err)
+}
+
+func BenchmarkFilterObserveFixed(b *testing.B) {
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, WithFixedSize())
+	if err != nil {
+		b.Fatal(err)
+	}
+	ob := &Observed{X: 1.0, Y: 2.0, Z: 3.0, XA: 1.0, YA: 1.0, ZA: 1.0, VXA: 0.1, VYA: 0.1, VZA: 0.1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.X = float64(i)
+		if _, err := f.Observe(1.0, ob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGeoFilterObserveFixed(b *testing.B) {
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1}, WithFixedSize())
+	if err != nil {
+		b.Fatal(err)
+	}
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              5.0,
+		SpeedAccuracy:      0.5,
+		Direction:          30.0,
+		DirectionAccuracy:  5.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   5.0,
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.Lat = 43.0 + 0.00001*float64(i%1000)
+		if _, err := g.Observe(1.0, ob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package kalman
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// ErrInvalidGate is returned when the innovation gate probability is not between 0 and 1.
+var ErrInvalidGate = fmt.Errorf("innovation gate probability must be between 0 and 1")
+
+// GateAction selects what the filter does with an observation outside of the innovation gate.
+type GateAction int
+
+const (
+	// RejectOutliers skips the observations outside of the gate.
+	RejectOutliers GateAction = iota
+	// InflateOutliers multiplies the measurement noise covariance of the observations outside
+	// of the gate by NIS/gate, so they move the estimate much less.
+	InflateOutliers
+)
+
+// Status tells what the filter did with an observation.
+type Status int
+
+const (
+	// Accepted means the observation was used as is.
+	Accepted Status = iota
+	// Rejected means the observation was outside of the gate and was skipped.
+	Rejected
+	// Inflated means the observation was outside of the gate and was used with inflated noise.
+	Inflated
+	// Dropped means the observation arrived too late and was skipped, see OutOfSequence.
+	Dropped
+)
+
+// String returns the status name.
+func (s Status) String() string {
+	switch s {
+	case Accepted:
+		return "accepted"
+	case Rejected:
+		return "rejected"
+	case Inflated:
+		return "inflated"
+	case Dropped:
+		return "dropped"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Result describes how an observation was processed.
+type Result struct {
+	Status Status  // What was done with the observation.
+	NIS    float64 // Normalized innovation squared (squared Mahalanobis distance), zero for the first observation.
+	Scale  float64 // Factor the measurement noise covariance was multiplied by, 1 unless inflated.
+}
+
+// accepted is the result for an observation that was used as is.
+var accepted = Result{Status: Accepted, Scale: 1.0}
+
+// Innovation is the difference between an observation and its prediction.
+type Innovation struct {
+	Residual   *mat.VecDense // Observed minus predicted values, for the observed components in X, Y, Z, VX, VY, VZ order.
+	Cov        *mat.Dense    // Residual covariance, H*P*H^T + R.
+	NIS        float64       // Normalized innovation squared, Residual^T*Cov^-1*Residual.
+	Components Component     // Observed components.
+}
+
+// gate is the chi-square innovation gate.
+type gate struct {
+	probability float64
+	action      GateAction
+	limits      [_N + 1]float64 // Gate for each number of observed components.
+}
+
+// newGate returns the gate that lets through the given fraction of consistent observations.
+func newGate(probability float64, action GateAction) *gate {
+	g := &gate{probability: probability, action: action}
+	if probability <= 0.0 || probability >= 1.0 {
+		return g
+	}
+	for k := 1; k <= _N; k++ {
+		g.limits[k] = distuv.ChiSquared{K: float64(k)}.Quantile(probability)
+	}
+	return g
+}
+
+// valid returns true if the gate can be used.
+func (g *gate) valid() bool {
+	return g.probability > 0.0 && g.probability < 1.0
+}
+
+// innovation returns the residual z - H*x and its covariance H*P*H^T + R.
+func innovation(x mat.Vector, p mat.Matrix, z mat.Vector, h, r mat.Matrix) (*mat.VecDense, *mat.Dense) {
+	y := mat.NewVecDense(z.Len(), nil)
+	y.MulVec(h, x)
+	y.SubVec(z, y)
+	var ph mat.Dense
+	ph.Mul(p, h.T())
+	s := mat.NewDense(z.Len(), z.Len(), nil)
+	s.Mul(h, &ph)
+	s.Add(s, r)
+	return y, s
+}
+
+// mahalanobis returns y^T*S^-1*y.
+func mahalanobis(y mat.Vector, s mat.Matrix) (float64, error) {
+	var w mat.VecDense
+	var chol mat.Cholesky
+	var err error
+	if chol.Factorize(symmetric(s)) {
+		err = chol.SolveVecTo(&w, y)
+	} else {
+		err = w.SolveVec(s, y)
+	}
+	if err != nil {
+		if _, ok := err.(mat.Condition); !ok {
+			return 0.0, err
+		}
+	}
+	return mat.Dot(y, &w), nil
+}
@@ -0,0 +1,132 @@
+package kalman
+
+import (
+	"testing"
+
+	"github.com/regnull/kalman/geo"
+	"github.com/stretchr/testify/assert"
+)
+
+// observeWithOutlier feeds a stationary track to the filter, followed by a fix 2 km off with
+// claimed 10 m accuracy, and returns the outlier result and the distance the estimate moved, in meters.
+func observeWithOutlier(assert *assert.Assertions, opts ...Option) (Result, float64) {
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	}, opts...)
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		HorizontalAccuracy: 10.0,
+		Components:         GeoPosition,
+	}
+	for i := 0; i < 10; i++ {
+		res, err := g.Observe(1.0, ob)
+		assert.NoError(err)
+		assert.Equal(Accepted, res.Status)
+	}
+	before := g.Estimate()
+	outlier := *ob
+	outlier.Lat += 2000.0 / geo.FastMetersPerDegreeLat(43.0)
+	res, err := g.Observe(1.0, &outlier)
+	assert.NoError(err)
+	after := g.Estimate()
+	return res, (after.Lat - before.Lat) * geo.FastMetersPerDegreeLat(43.0)
+}
+
+func TestGateOutlier(t *testing.T) {
+	assert := assert.New(t)
+
+	res, moved := observeWithOutlier(assert)
+	assert.Equal(Accepted, res.Status)
+	assert.True(res.NIS > 1000.0)
+	assert.True(moved > 100.0, "moved %f", moved)
+
+	res, rejected := observeWithOutlier(assert, WithInnovationGate(0.999, RejectOutliers))
+	assert.Equal(Rejected, res.Status)
+	assert.True(res.NIS > 1000.0)
+	assert.InDelta(0.0, rejected, 1e-6)
+
+	res, inflated := observeWithOutlier(assert, WithInnovationGate(0.999, InflateOutliers))
+	assert.Equal(Inflated, res.Status)
+	assert.True(res.Scale > 1.0)
+	assert.True(inflated > 0.0)
+	assert.True(inflated < moved/10.0, "inflated %f, not gated %f", inflated, moved)
+}
+
+func TestGateDiagnostics(t *testing.T) {
+	// Rejected outliers are not recorded in the diagnostics, inflated ones are.
+	assert := assert.New(t)
+	for _, action := range []GateAction{RejectOutliers, InflateOutliers} {
+		f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0},
+			WithInnovationGate(0.999, action), WithDiagnostics(10))
+		assert.NoError(err)
+		ob := &Observed{XA: 1.0, YA: 1.0, ZA: 1.0, Components: ComponentPosition}
+		for i := 0; i < 5; i++ {
+			_, err = f.Observe(1.0, ob)
+			assert.NoError(err)
+		}
+		outlier := *ob
+		outlier.X = 1000.0
+		res, err := f.Observe(1.0, &outlier)
+		assert.NoError(err)
+		nis := f.Diagnostics().NIS()
+		if action == RejectOutliers {
+			assert.Equal(Rejected, res.Status)
+			assert.Len(nis, 4)
+			assert.True(nis[len(nis)-1] < 1000.0)
+		} else {
+			assert.Equal(Inflated, res.Status)
+			assert.Len(nis, 5)
+			assert.Equal(res.NIS, nis[len(nis)-1])
+		}
+	}
+}
+
+func TestInnovation(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	ob := &Observed{
+		X:          1.0,
+		Y:          2.0,
+		XA:         2.0,
+		YA:         2.0,
+		ZA:         2.0,
+		Components: ComponentPosition,
+	}
+	_, err = f.Innovation(1.0, ob)
+	assert.Equal(ErrNotInitialized, err)
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+
+	ob.X = 4.0
+	inn, err := f.Innovation(1.0, ob)
+	assert.NoError(err)
+	assert.Equal(ComponentPosition, inn.Components)
+	assert.Equal(3, inn.Residual.Len())
+	assert.InDelta(3.0, inn.Residual.AtVec(0), 1e-9)
+	assert.InDelta(0.0, inn.Residual.AtVec(1), 1e-9)
+	// Position variance 4 plus the velocity variance 1e6 carried over one second, plus R.
+	s := 4.0 + unobservedVariance + 4.0 + 0.01/3.0
+	assert.InDelta(s, inn.Cov.At(0, 0), 1e-6)
+	assert.InDelta(9.0/s, inn.NIS, 1e-9)
+
+	// Innovation doesn't change the filter, and Observe reports the same NIS.
+	assert.InDelta(1.0, f.state.AtVec(_X), 1e-12)
+	res, err := f.Observe(1.0, ob)
+	assert.NoError(err)
+	assert.Equal(Accepted, res.Status)
+	assert.InDelta(inn.NIS, res.NIS, 1e-12)
+	assert.Equal(1.0, res.Scale)
+}
+
+func TestInvalidGate(t *testing.T) {
+	assert := assert.New(t)
+	for _, p := range []float64{0.0, 1.0, -0.5, 2.0} {
+		_, err := NewFilter(&ProcessNoise{}, WithInnovationGate(p, RejectOutliers))
+		assert.Equal(ErrInvalidGate, err)
+	}
+}
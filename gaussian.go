@@ -0,0 +1,102 @@
+package kalman
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// gaussian is a state estimate with its covariance, shared by the filters.
+type gaussian struct {
+	state   *mat.VecDense // State.
+	cov     *mat.Dense    // Covariance.
+	sqrtCov *mat.Dense    // Square root of the covariance, only used by the square root filter.
+	opts    options       // Configuration.
+}
+
+// reset sets the state and covariance.
+func (g *gaussian) reset(x *mat.VecDense, p *mat.Dense) {
+	g.state = x
+	g.cov = p
+	if g.opts.squareRoot {
+		g.sqrtCov = sqrtPSD(p)
+	}
+}
+
+// predict propagates the state with the transition f and process noise covariance q.
+func (g *gaussian) predict(f, q mat.Matrix) {
+	if g.opts.squareRoot {
+		sqrtPredict(g.state, g.sqrtCov, f, q)
+		g.cov.Mul(g.sqrtCov, g.sqrtCov.T())
+		return
+	}
+	predict(g.state, g.cov, f, q)
+}
+
+// correct updates the state with the measurement z = H*x + v, v ~ N(0, R).
+func (g *gaussian) correct(z mat.Vector, h, r mat.Matrix) error {
+	if g.opts.squareRoot {
+		if err := sqrtCorrect(g.state, g.sqrtCov, z, h, r); err != nil {
+			return err
+		}
+		g.cov.Mul(g.sqrtCov, g.sqrtCov.T())
+		return nil
+	}
+	return correct(g.state, g.cov, z, h, r, g.opts.updateForm)
+}
+
+// estimate returns the state estimate.
+func (g *gaussian) estimate() *Estimated {
+	return &Estimated{
+		X:   g.value(_X),
+		Y:   g.value(_Y),
+		Z:   g.value(_Z),
+		VX:  g.value(_VX),
+		VY:  g.value(_VY),
+		VZ:  g.value(_VZ),
+		AX:  g.value(_AX),
+		AY:  g.value(_AY),
+		AZ:  g.value(_AZ),
+		XA:  g.accuracy(_X),
+		YA:  g.accuracy(_Y),
+		ZA:  g.accuracy(_Z),
+		VXA: g.accuracy(_VX),
+		VYA: g.accuracy(_VY),
+		VZA: g.accuracy(_VZ),
+		AXA: g.accuracy(_AX),
+		AYA: g.accuracy(_AY),
+		AZA: g.accuracy(_AZ),
+
+		QScale: 1.0,
+		RScale: 1.0,
+		Cov:    mat.DenseCopyOf(g.cov),
+	}
+}
+
+// value returns the i-th state component, or zero if the model doesn't have it.
+func (g *gaussian) value(i int) float64 {
+	if i >= g.state.Len() {
+		return 0.0
+	}
+	return g.state.AtVec(i)
+}
+
+// accuracy returns the standard deviation of the i-th state component, or zero if the model doesn't have it.
+func (g *gaussian) accuracy(i int) float64 {
+	if i >= g.state.Len() {
+		return 0.0
+	}
+	return math.Sqrt(g.cov.At(i, i))
+}
+
+// snapshot returns a copy of the state and covariance, and its square root if there is one.
+func (g *gaussian) snapshot() gaussian {
+	s := gaussian{
+		state: mat.VecDenseCopyOf(g.state),
+		cov:   mat.DenseCopyOf(g.cov),
+	}
+	if g.sqrtCov != nil {
+		s.sqrtCov = mat.DenseCopyOf(g.sqrtCov)
+	}
+	return s
+}
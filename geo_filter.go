@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/regnull/kalman/geo"
+	"gonum.org/v1/gonum/mat"
 )
 
 const (
@@ -16,6 +17,13 @@ const (
 	incline          = 5   // Degrees, used to estimate altitude random step.
 )
 
+// Components of GeoObserved, to be combined in GeoObserved.Components.
+const (
+	GeoPosition = ComponentX | ComponentY                 // Latitude and longitude.
+	GeoAltitude = ComponentZ                              // Altitude.
+	GeoVelocity = ComponentVX | ComponentVY | ComponentVZ // Speed and direction, vertical speed is observed as zero.
+)
+
 var sqrtOf2 = math.Sqrt(2)                              // Pre-computed to speed up computations.
 var inclineFactor = math.Sin(incline * math.Pi / 180.0) // Pre-computed to speed up computations.
 
@@ -32,29 +40,55 @@ type GeoProcessNoise struct {
 	DistancePerSecond float64
 	// SpeedPerSecond is the expected speed per second change.
 	SpeedPerSecond float64
+	// AccelerationPerSecond is the expected acceleration per second change. If set, the filter
+	// tracks acceleration, which reduces the lag for vehicles that brake and accelerate hard.
+	AccelerationPerSecond float64
 }
 
 // GeoObserved represents a single observation, in geographical coordinates and altitude.
 type GeoObserved struct {
-	Lat, Lng, Altitude float64 // Geographical coordinates (in degrees) and latitude.
-	Speed              float64 // Speed, in meters per second.
-	SpeedAccuracy      float64 // Speed accuracy, in meters per second.
-	Direction          float64 // Travel direction, in degrees from North, 0 to 360 range.
-	DirectionAccuracy  float64 // Direction accuracy, in degrees.
-	HorizontalAccuracy float64 // Horizontal accuracy, in meters.
-	VerticalAccuracy   float64 // Vertical accuracy, in meters.
+	Lat, Lng, Altitude float64   // Geographical coordinates (in degrees) and latitude.
+	Speed              float64   // Speed, in meters per second.
+	SpeedAccuracy      float64   // Speed accuracy, in meters per second.
+	Direction          float64   // Travel direction, in degrees from North, 0 to 360 range.
+	DirectionAccuracy  float64   // Direction accuracy, in degrees.
+	HorizontalAccuracy float64   // Horizontal accuracy, in meters.
+	VerticalAccuracy   float64   // Vertical accuracy, in meters.
+	Components         Component // Observed components (GeoPosition, GeoAltitude, GeoVelocity), zero means all of them.
+
+	// Horizontal error ellipse, used instead of HorizontalAccuracy if SemiMajorAccuracy is not zero.
+	SemiMajorAccuracy  float64 // Accuracy along the semi-major axis, in meters.
+	SemiMinorAccuracy  float64 // Accuracy along the semi-minor axis, in meters.
+	EllipseOrientation float64 // Direction of the semi-major axis, in degrees from North.
 }
 
 // GeoEstimated contains estimated location, obtained by processing several observed locations.
 type GeoEstimated struct {
 	Lat, Lng, Altitude float64
-	Speed              float64
-	Direction          float64
-	HorizontalAccuracy float64
+	Speed              float64 // Speed, in meters per second.
+	Direction          float64 // Travel direction, in degrees from North, 0 to 360 range.
+	HorizontalAccuracy float64 // Horizontal accuracy, in meters.
+	VerticalAccuracy   float64 // Vertical accuracy, in meters.
+	SpeedAccuracy      float64 // Speed accuracy, in meters per second.
+	QScale             float64 // Process noise scale, 1 unless adapted, see AdaptiveNoise.
+	RScale             float64 // Measurement noise scale, 1 unless adapted, see AdaptiveNoise.
 }
 
 // NewGeoFilter creates and returns a new GeoFilter.
-func NewGeoFilter(d *GeoProcessNoise) (*GeoFilter, error) {
+func NewGeoFilter(d *GeoProcessNoise, opts ...Option) (*GeoFilter, error) {
+	m, err := newGeoMotionModel(d)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFilterWithModel(m, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoFilter{filter: f}, nil
+}
+
+// newGeoMotionModel returns the motion model in geographical coordinates for the process noise.
+func newGeoMotionModel(d *GeoProcessNoise) (MotionModel, error) {
 	metersPerDegreeLat := geo.FastMetersPerDegreeLat(d.BaseLat)
 	metersPerDegreeLng := geo.FastMetersPerDegreeLng(d.BaseLat)
 
@@ -64,42 +98,166 @@ func NewGeoFilter(d *GeoProcessNoise) (*GeoFilter, error) {
 	dsvx := d.SpeedPerSecond / sqrtOf2 / metersPerDegreeLat
 	dsvy := d.SpeedPerSecond / sqrtOf2 / metersPerDegreeLng
 	dsvz := d.SpeedPerSecond * inclineFactor
-	f, err := NewFilter(&ProcessNoise{
+	dsax := d.AccelerationPerSecond / sqrtOf2 / metersPerDegreeLat
+	dsay := d.AccelerationPerSecond / sqrtOf2 / metersPerDegreeLng
+	dsaz := d.AccelerationPerSecond * inclineFactor
+	noise := &ProcessNoise{
 		ST:  1.0,
 		SX:  dx,
 		SY:  dy,
 		SZ:  dz,
 		SVX: dsvx,
 		SVY: dsvy,
-		SVZ: dsvz})
-	if err != nil {
-		return nil, err
+		SVZ: dsvz,
+		SAX: dsax,
+		SAY: dsay,
+		SAZ: dsaz}
+	if d.AccelerationPerSecond > 0 {
+		return NewConstantAcceleration(noise)
 	}
-	return &GeoFilter{filter: f}, nil
+	return NewConstantVelocity(noise)
+}
+
+// Predict advances the estimated location by td seconds without an observation.
+func (g *GeoFilter) Predict(td float64) error {
+	return g.filter.Predict(td)
+}
+
+// Init sets the location estimate to the prior, such as the last saved location, instead of the
+// first observation. Zero accuracy means the component is unknown. The speed accuracy applies to
+// all the directions, the vertical speed is taken as zero.
+func (g *GeoFilter) Init(prior *GeoEstimated) error {
+	if prior == nil || !(prior.HorizontalAccuracy >= 0.0) || !(prior.VerticalAccuracy >= 0.0) || !(prior.SpeedAccuracy >= 0.0) {
+		return ErrInvalidPrior
+	}
+	if !finite([]float64{prior.Lat, prior.Lng, prior.Altitude, prior.Speed, prior.Direction}) {
+		return ErrInvalidPrior
+	}
+	n := g.filter.model.Dim()
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(prior.Lat)
+	metersPerDegreeLng := geo.FastMetersPerDegreeLng(prior.Lat)
+	directionRad := prior.Direction * math.Pi / 180.0
+	x := mat.NewVecDense(n, nil)
+	x.SetVec(_LAT, prior.Lat)
+	x.SetVec(_LNG, prior.Lng)
+	x.SetVec(_ALTITUDE, prior.Altitude)
+	x.SetVec(_VLAT, prior.Speed*math.Cos(directionRad)/metersPerDegreeLat)
+	x.SetVec(_VLNG, prior.Speed*math.Sin(directionRad)/metersPerDegreeLng)
+	p := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		p.Set(i, i, unobservedVariance)
+	}
+	if ha := prior.HorizontalAccuracy; ha > 0.0 {
+		p.Set(_LAT, _LAT, ha*ha/(metersPerDegreeLat*metersPerDegreeLat))
+		p.Set(_LNG, _LNG, ha*ha/(metersPerDegreeLng*metersPerDegreeLng))
+	}
+	if va := prior.VerticalAccuracy; va > 0.0 {
+		p.Set(_ALTITUDE, _ALTITUDE, va*va)
+	}
+	if sa := prior.SpeedAccuracy; sa > 0.0 {
+		p.Set(_VLAT, _VLAT, sa*sa/(metersPerDegreeLat*metersPerDegreeLat))
+		p.Set(_VLNG, _VLNG, sa*sa/(metersPerDegreeLng*metersPerDegreeLng))
+		p.Set(_VZ, _VZ, minSpeedAccuracy*minSpeedAccuracy)
+	}
+	return g.filter.Init(x, p)
 }
 
-func (g *GeoFilter) Observe(td float64, ob *GeoObserved) error {
+// Observe processes a single observation, td is the time since last update, in seconds.
+// The result tells whether the observation passed the innovation gate, if there is one.
+func (g *GeoFilter) Observe(td float64, ob *GeoObserved) (Result, error) {
+	return g.filter.observe(td, g.measurement(ob))
+}
+
+// Innovation returns the innovation of the observation made td seconds after the last update,
+// without changing the filter. The residual is in degrees for latitude and longitude, meters for
+// altitude and degrees per second for the horizontal speed.
+func (g *GeoFilter) Innovation(td float64, ob *GeoObserved) (*Innovation, error) {
+	return g.filter.innovation(td, toMeasurement(ob))
+}
+
+// NEES returns the normalized estimation error squared of the current estimate for the ground
+// truth, using only the components present in it. The value is recorded in the diagnostics, if enabled.
+func (g *GeoFilter) NEES(truth *GeoObserved) (float64, error) {
+	o := toObserved(truth)
+	return g.filter.NEES(&o)
+}
+
+// Diagnostics returns the consistency statistics, or nil if they are not enabled with WithDiagnostics.
+func (g *GeoFilter) Diagnostics() *Diagnostics {
+	return g.filter.diag
+}
+
+// toObserved converts the observation to degrees and degrees per second.
+func toObserved(ob *GeoObserved) Observed {
 	metersPerDegreeLat := geo.FastMetersPerDegreeLat(ob.Lat)
 	metersPerDegreeLng := geo.FastMetersPerDegreeLng(ob.Lat)
 	directionRad := ob.Direction * math.Pi / 180.0
 	directionRadAccuracy := ob.DirectionAccuracy * math.Pi / 180.0
 	speedLat := ob.Speed * math.Cos(directionRad) / metersPerDegreeLat
 	speedLng := ob.Speed * math.Sin(directionRad) / metersPerDegreeLng
-	ob1 := &Observed{
+	latAccuracy, lngAccuracy := ob.HorizontalAccuracy, ob.HorizontalAccuracy
+	if ob.SemiMajorAccuracy != 0.0 {
+		north, east, _ := ellipseCovariance(ob)
+		latAccuracy, lngAccuracy = math.Sqrt(north), math.Sqrt(east)
+	}
+	return Observed{
 		X:   ob.Lat,
 		Y:   ob.Lng,
 		Z:   ob.Altitude,
 		VX:  speedLat,
 		VY:  speedLng,
 		VZ:  0.0, // There is no way to estimate vertical speed.
-		XA:  ob.HorizontalAccuracy / metersPerDegreeLat,
-		YA:  ob.HorizontalAccuracy / metersPerDegreeLng,
+		XA:  latAccuracy / metersPerDegreeLat,
+		YA:  lngAccuracy / metersPerDegreeLng,
 		ZA:  ob.VerticalAccuracy,
 		VXA: speedLatAccuracy(ob.Speed, ob.SpeedAccuracy, directionRad, directionRadAccuracy, metersPerDegreeLat),
 		VYA: speedLngAccuracy(ob.Speed, ob.SpeedAccuracy, directionRad, directionRadAccuracy, metersPerDegreeLng),
 		VZA: minSpeedAccuracy,
+
+		Components: ob.Components,
+	}
+}
+
+// toMeasurement returns the measurement for the observation in degrees and degrees per second.
+// The errors of the latitude and longitude speed are correlated, since both are computed from
+// the same speed and direction, and so are the position errors with an error ellipse.
+func toMeasurement(ob *GeoObserved) *measurement {
+	o := toObserved(ob)
+	m := newMeasurement(&o)
+	correlate(m, ob)
+	return m
+}
+
+// measurement returns the workspace measurement for the observation, see toMeasurement.
+func (g *GeoFilter) measurement(ob *GeoObserved) *measurement {
+	o := toObserved(ob)
+	m := g.filter.work.measurement(&o)
+	correlate(m, ob)
+	return m
+}
+
+// correlate sets the covariances of the latitude and longitude speed errors, and of the position
+// errors if the observation has an error ellipse, of the measurement.
+func correlate(m *measurement, ob *GeoObserved) {
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(ob.Lat)
+	metersPerDegreeLng := geo.FastMetersPerDegreeLng(ob.Lat)
+	if ob.SemiMajorAccuracy != 0.0 {
+		_, _, c := ellipseCovariance(ob)
+		m.setCovariance(_LAT, _LNG, c/(metersPerDegreeLat*metersPerDegreeLng))
 	}
-	return g.filter.Observe(td, ob1)
+	directionRad := ob.Direction * math.Pi / 180.0
+	directionRadAccuracy := ob.DirectionAccuracy * math.Pi / 180.0
+	m.setCovariance(_VLAT, _VLNG, speedLatLngCovariance(ob.Speed, ob.SpeedAccuracy, directionRad,
+		directionRadAccuracy, metersPerDegreeLat, metersPerDegreeLng))
+}
+
+// ellipseCovariance returns the variances of the north and east position errors and their
+// covariance for the error ellipse of the observation, in square meters.
+func ellipseCovariance(ob *GeoObserved) (north, east, c float64) {
+	sin, cos := math.Sincos(ob.EllipseOrientation * math.Pi / 180.0)
+	a2 := ob.SemiMajorAccuracy * ob.SemiMajorAccuracy
+	b2 := ob.SemiMinorAccuracy * ob.SemiMinorAccuracy
+	return a2*cos*cos + b2*sin*sin, a2*sin*sin + b2*cos*cos, (a2 - b2) * sin * cos
 }
 
 // Estimate returns the best location estimate.
@@ -107,22 +265,54 @@ func (g *GeoFilter) Estimate() *GeoEstimated {
 	if g.filter.state == nil {
 		return nil
 	}
-	lat := g.filter.state.AtVec(_LAT)
+	return g.scaled(geoEstimate(g.filter.state, g.filter.cov))
+}
+
+// scaled sets the adaptive noise scales of the estimate, if the noise is adapted.
+func (g *GeoFilter) scaled(e *GeoEstimated) *GeoEstimated {
+	if a := g.filter.adapt; a != nil {
+		e.QScale = a.qScale
+		e.RScale = a.rScale
+	}
+	return e
+}
+
+// geoEstimate returns the location estimate for the state and covariance in degrees.
+func geoEstimate(state mat.Vector, cov mat.Matrix) *GeoEstimated {
+	lat := state.AtVec(_LAT)
 	metersPerDegreeLat := geo.FastMetersPerDegreeLat(lat)
 	metersPerDegreeLng := geo.FastMetersPerDegreeLng(lat)
-	speedLatMeters := g.filter.state.AtVec(_VLAT) * metersPerDegreeLat
-	speedLngMeters := g.filter.state.AtVec(_VLNG) * metersPerDegreeLng
+	speedLatMeters := state.AtVec(_VLAT) * metersPerDegreeLat
+	speedLngMeters := state.AtVec(_VLNG) * metersPerDegreeLng
 	speed := math.Sqrt(speedLatMeters*speedLatMeters + speedLngMeters*speedLngMeters)
-	haLatSquared := g.filter.cov.At(_LAT, _LAT) * metersPerDegreeLat * metersPerDegreeLat
-	haLngSquared := g.filter.cov.At(_LNG, _LNG) * metersPerDegreeLng * metersPerDegreeLng
+	haLatSquared := cov.At(_LAT, _LAT) * metersPerDegreeLat * metersPerDegreeLat
+	haLngSquared := cov.At(_LNG, _LNG) * metersPerDegreeLng * metersPerDegreeLng
 	ha := math.Max(math.Sqrt(haLatSquared), math.Sqrt(haLngSquared))
 
+	// Speed variance along the direction of travel, or the largest one when standing still.
+	vLatSquared := cov.At(_VLAT, _VLAT) * metersPerDegreeLat * metersPerDegreeLat
+	vLngSquared := cov.At(_VLNG, _VLNG) * metersPerDegreeLng * metersPerDegreeLng
+	vLatLng := cov.At(_VLAT, _VLNG) * metersPerDegreeLat * metersPerDegreeLng
+	direction := 0.0
+	sa := math.Sqrt(math.Max(vLatSquared, vLngSquared))
+	if speed > 0.0 {
+		direction = math.Mod(math.Atan2(speedLngMeters, speedLatMeters)*180.0/math.Pi+360.0, 360.0)
+		sa = math.Sqrt(math.Max(0.0, speedLatMeters*speedLatMeters*vLatSquared+
+			2.0*speedLatMeters*speedLngMeters*vLatLng+
+			speedLngMeters*speedLngMeters*vLngSquared) / (speed * speed))
+	}
+
 	return &GeoEstimated{
-		Lat:                g.filter.state.AtVec(_LAT),
-		Lng:                g.filter.state.AtVec(_LNG),
-		Altitude:           g.filter.state.AtVec(_ALTITUDE),
+		Lat:                state.AtVec(_LAT),
+		Lng:                state.AtVec(_LNG),
+		Altitude:           state.AtVec(_ALTITUDE),
 		Speed:              speed,
+		Direction:          direction,
 		HorizontalAccuracy: ha,
+		VerticalAccuracy:   math.Sqrt(cov.At(_ALTITUDE, _ALTITUDE)),
+		SpeedAccuracy:      sa,
+		QScale:             1.0,
+		RScale:             1.0,
 	}
 }
 
@@ -137,3 +327,10 @@ func speedLngAccuracy(speed float64, speedAccuracy float64, directionRad float64
 	dr := speed * math.Cos(directionRad) / metersPerDegreeLng * directionRadAccuracy
 	return math.Max(math.Sqrt(ds*ds+dr*dr), minSpeedAccuracy/metersPerDegreeLng)
 }
+
+// speedLatLngCovariance returns the covariance of the latitude and longitude speed errors, J*C*J^T
+// for the Jacobian J of the speeds by the speed and direction, and their covariance C.
+func speedLatLngCovariance(speed float64, speedAccuracy float64, directionRad float64, directionRadAccuracy float64, metersPerDegreeLat float64, metersPerDegreeLng float64) float64 {
+	v := speedAccuracy*speedAccuracy - speed*speed*directionRadAccuracy*directionRadAccuracy
+	return math.Sin(directionRad) * math.Cos(directionRad) * v / (metersPerDegreeLat * metersPerDegreeLng)
+}
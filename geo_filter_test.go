@@ -7,6 +7,7 @@ import (
 
 	"github.com/regnull/kalman/geo"
 	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
 )
 
 func TestSpeedLatAccuracy(t *testing.T) {
@@ -44,14 +45,16 @@ func TestImproveGeoAccuracy(t *testing.T) {
 		VerticalAccuracy:   10.0,
 	}
 	// First observation.
-	assert.NoError(g.Observe(0.0, ob))
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
 	e := g.Estimate()
 	assert.InDelta(43.0, e.Lat, 0.01)
 	assert.InDelta(-71.0, e.Lng, 0.01)
 	assert.InDelta(100.0, e.HorizontalAccuracy, 0.01)
 
 	// Second observation.
-	assert.NoError(g.Observe(0.0, ob))
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
 	e = g.Estimate()
 	assert.InDelta(43.0, e.Lat, 0.01)
 	assert.InDelta(-71.0, e.Lng, 0.01)
@@ -59,7 +62,8 @@ func TestImproveGeoAccuracy(t *testing.T) {
 	assert.InDelta(70.71067811865476, e.HorizontalAccuracy, 0.01)
 
 	// Third observation.
-	assert.NoError(g.Observe(0.0, ob))
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
 	e = g.Estimate()
 	assert.InDelta(43.0, e.Lat, 0.01)
 	assert.InDelta(-71.0, e.Lng, 0.01)
@@ -86,7 +90,8 @@ func TestMoreRecentIsBetter(t *testing.T) {
 		HorizontalAccuracy: 10.0,
 		VerticalAccuracy:   10.0,
 	}
-	assert.NoError(g.Observe(0.0, ob))
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
 	e := g.Estimate()
 	assert.InDelta(43.0, e.Lat, 0.01)
 	assert.InDelta(-71.0, e.Lng, 0.01)
@@ -94,9 +99,11 @@ func TestMoreRecentIsBetter(t *testing.T) {
 
 	// Second observation is a bit further away, and it happens 10 seconds later.
 	ob.Lat = 43.01
-	assert.NoError(g.Observe(100.0, ob))
+	_, err = g.Observe(100.0, ob)
+	assert.NoError(err)
 	e = g.Estimate()
-	assert.InDelta(43.006, e.Lat, 0.0001)
+	// Speed uncertainty accumulated over 100 seconds adds to the location uncertainty.
+	assert.InDelta(43.0086, e.Lat, 0.0001)
 }
 
 func TestGeoConvergeOnLocation(t *testing.T) {
@@ -189,12 +196,12 @@ func TestGeoConvergeFasterWithMoreNoise(t *testing.T) {
 }
 
 func geoConverge(filter *GeoFilter, loc0, loc1 *GeoObserved, td float64, distance float64, maxIter int) (int, error) {
-	if err := filter.Observe(td, loc0); err != nil {
+	if _, err := filter.Observe(td, loc0); err != nil {
 		return 0, err
 	}
 
 	for i := 0; i < maxIter; i++ {
-		if err := filter.Observe(td, loc1); err != nil {
+		if _, err := filter.Observe(td, loc1); err != nil {
 			return 0, err
 		}
 		e := filter.Estimate()
@@ -212,3 +219,397 @@ func geoConverge(filter *GeoFilter, loc0, loc1 *GeoObserved, td float64, distanc
 	}
 	return maxIter, fmt.Errorf("max iteration reached")
 }
+
+func TestGeoPredict(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 1.0, // Meters.
+		SpeedPerSecond:    0.1, // Meters per second.
+	})
+	assert.NoError(err)
+	assert.Equal(ErrNotInitialized, g.Predict(1.0))
+
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Altitude:           100.0,
+		Speed:              10.0,
+		SpeedAccuracy:      0.1,
+		Direction:          0.0,
+		DirectionAccuracy:  1.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   10.0,
+	}
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
+
+	// Moving north at 10 m/s for 10 seconds.
+	assert.NoError(g.Predict(10.0))
+	e := g.Estimate()
+	d := (e.Lat - ob.Lat) * geo.FastMetersPerDegreeLat(ob.Lat)
+	assert.InDelta(100.0, d, 1.0)
+	assert.InDelta(-71.0, e.Lng, 1e-6)
+	assert.True(e.HorizontalAccuracy > 10.0)
+}
+
+func TestGeoPositionOnly(t *testing.T) {
+	// Speed is inferred from the position-only fixes.
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1, // Meters.
+		SpeedPerSecond:    0.1, // Meters per second.
+	})
+	assert.NoError(err)
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	for i := 0; i < 30; i++ {
+		_, err = g.Observe(1.0, &GeoObserved{
+			Lat:                43.0 + 5.0*float64(i)/metersPerDegreeLat,
+			Lng:                -71.0,
+			HorizontalAccuracy: 5.0,
+			Components:         GeoPosition,
+		})
+		assert.NoError(err)
+	}
+	e := g.Estimate()
+	assert.InDelta(5.0, e.Speed, 0.5)
+	assert.InDelta(43.0+145.0/metersPerDegreeLat, e.Lat, 2.0/metersPerDegreeLat)
+}
+
+func TestGeoAltitudeOnly(t *testing.T) {
+	// A barometer sample changes the altitude only.
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{})
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Altitude:           100.0,
+		SpeedAccuracy:      0.01,
+		DirectionAccuracy:  5.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   10.0,
+	}
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
+	_, err = g.Observe(0.0, &GeoObserved{
+		Lat:              44.0, // Not observed, must be ignored.
+		Altitude:         110.0,
+		VerticalAccuracy: 10.0,
+		Components:       GeoAltitude,
+	})
+	assert.NoError(err)
+	e := g.Estimate()
+	assert.InDelta(43.0, e.Lat, 1e-9)
+	assert.InDelta(-71.0, e.Lng, 1e-9)
+	assert.InDelta(105.0, e.Altitude, 1e-6)
+	assert.InDelta(10.0, e.HorizontalAccuracy, 1e-6)
+}
+
+func TestGeoAcceleration(t *testing.T) {
+	// With AccelerationPerSecond set, the filter uses the constant acceleration model.
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:               43.0,
+		DistancePerSecond:     1.0,
+		SpeedPerSecond:        0.1,
+		AccelerationPerSecond: 0.1,
+	})
+	assert.NoError(err)
+	assert.Equal(9, g.filter.model.Dim())
+
+	g, err = NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 1.0,
+		SpeedPerSecond:    0.1,
+	})
+	assert.NoError(err)
+	assert.Equal(6, g.filter.model.Dim())
+}
+
+func TestGeoLongRunAccuracy(t *testing.T) {
+	// Horizontal accuracy must stay a number after many updates with very accurate fixes.
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.01,
+		SpeedPerSecond:    0.01,
+	})
+	assert.NoError(err)
+	for i := 0; i < 10000; i++ {
+		_, err = g.Observe(0.1, &GeoObserved{
+			Lat:                43.0 + float64(i%5)*1e-7,
+			Lng:                -71.0,
+			Altitude:           100.0,
+			SpeedAccuracy:      0.001,
+			DirectionAccuracy:  0.01,
+			HorizontalAccuracy: 0.001,
+			VerticalAccuracy:   0.001,
+		})
+		assert.NoError(err)
+	}
+	e := g.Estimate()
+	assert.False(math.IsNaN(e.HorizontalAccuracy))
+	assert.True(e.HorizontalAccuracy > 0.0)
+}
+
+func TestGeoSquareRoot(t *testing.T) {
+	// The square root filter gives the same estimates as the regular one.
+	assert := assert.New(t)
+	d := &GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 1.0,
+		SpeedPerSecond:    0.1,
+	}
+	g, err := NewGeoFilter(d)
+	assert.NoError(err)
+	s, err := NewGeoFilter(d, WithSquareRoot())
+	assert.NoError(err)
+	for i := 0; i < 20; i++ {
+		ob := &GeoObserved{
+			Lat:                43.0 + float64(i)*1e-5,
+			Lng:                -71.0 + float64(i%3)*1e-5,
+			Altitude:           100.0,
+			Speed:              1.0,
+			SpeedAccuracy:      0.5,
+			Direction:          10.0,
+			DirectionAccuracy:  5.0,
+			HorizontalAccuracy: 10.0,
+			VerticalAccuracy:   10.0,
+		}
+		_, err = g.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = s.Observe(1.0, ob)
+		assert.NoError(err)
+	}
+	e1 := g.Estimate()
+	e2 := s.Estimate()
+	assert.InDelta(e1.Lat, e2.Lat, 1e-9)
+	assert.InDelta(e1.Lng, e2.Lng, 1e-9)
+	assert.InDelta(e1.Altitude, e2.Altitude, 1e-6)
+	assert.InDelta(e1.HorizontalAccuracy, e2.HorizontalAccuracy, 1e-6)
+}
+
+func TestGeoCorrelatedSpeed(t *testing.T) {
+	// The speed covariance in degrees is J*C*J^T for the Jacobian J of the latitude and
+	// longitude speeds by the speed and direction.
+	assert := assert.New(t)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              10.0,
+		SpeedAccuracy:      1.0,
+		Direction:          30.0,
+		DirectionAccuracy:  10.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   10.0,
+	}
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(ob.Lat)
+	metersPerDegreeLng := geo.FastMetersPerDegreeLng(ob.Lat)
+	dir := ob.Direction * math.Pi / 180.0
+	j := mat.NewDense(2, 2, []float64{
+		math.Cos(dir) / metersPerDegreeLat, -ob.Speed * math.Sin(dir) / metersPerDegreeLat,
+		math.Sin(dir) / metersPerDegreeLng, ob.Speed * math.Cos(dir) / metersPerDegreeLng,
+	})
+	dirAccuracy := ob.DirectionAccuracy * math.Pi / 180.0
+	c := mat.NewDiagDense(2, []float64{ob.SpeedAccuracy * ob.SpeedAccuracy, dirAccuracy * dirAccuracy})
+	var jc, expected mat.Dense
+	jc.Mul(j, c)
+	expected.Mul(&jc, j.T())
+
+	m := toMeasurement(ob)
+	assert.True(mat.EqualApprox(&expected, m.r.Slice(_VLAT, _VLNG+1, _VLAT, _VLNG+1), 1e-20))
+	assert.True(m.r.At(_VLAT, _VLNG) < 0.0)
+
+	// Going north, the errors are not correlated.
+	ob.Direction = 0.0
+	m = toMeasurement(ob)
+	assert.InDelta(0.0, m.r.At(_VLAT, _VLNG), 1e-25)
+
+	// The filter uses the full covariance from the first observation.
+	ob.Direction = 30.0
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 1.0, SpeedPerSecond: 0.1})
+	assert.NoError(err)
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
+	assert.InDelta(expected.At(0, 1), g.filter.cov.At(_VLAT, _VLNG), 1e-20)
+}
+
+func TestGeoErrorEllipse(t *testing.T) {
+	// The position covariance in meters is R*diag(a^2, b^2)*R^T for the rotation R of the
+	// semi-major axis from North.
+	assert := assert.New(t)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              10.0,
+		SpeedAccuracy:      1.0,
+		DirectionAccuracy:  10.0,
+		VerticalAccuracy:   10.0,
+		SemiMajorAccuracy:  20.0,
+		SemiMinorAccuracy:  5.0,
+		EllipseOrientation: 30.0,
+	}
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(ob.Lat)
+	metersPerDegreeLng := geo.FastMetersPerDegreeLng(ob.Lat)
+	o := ob.EllipseOrientation * math.Pi / 180.0
+	rot := mat.NewDense(2, 2, []float64{
+		math.Cos(o) / metersPerDegreeLat, -math.Sin(o) / metersPerDegreeLat,
+		math.Sin(o) / metersPerDegreeLng, math.Cos(o) / metersPerDegreeLng,
+	})
+	c := mat.NewDiagDense(2, []float64{ob.SemiMajorAccuracy * ob.SemiMajorAccuracy, ob.SemiMinorAccuracy * ob.SemiMinorAccuracy})
+	var rc, expected mat.Dense
+	rc.Mul(rot, c)
+	expected.Mul(&rc, rot.T())
+
+	m := toMeasurement(ob)
+	assert.True(mat.EqualApprox(&expected, m.r.Slice(_LAT, _LNG+1, _LAT, _LNG+1), 1e-20))
+	assert.True(m.r.At(_LAT, _LNG) > 0.0)
+
+	// A circle is the same as the horizontal accuracy.
+	circle := *ob
+	circle.SemiMinorAccuracy = circle.SemiMajorAccuracy
+	circle.HorizontalAccuracy = circle.SemiMajorAccuracy
+	m = toMeasurement(&circle)
+	circle.SemiMajorAccuracy = 0.0
+	assert.True(mat.EqualApprox(toMeasurement(&circle).r, m.r, 1e-20))
+
+	// The filter uses the full covariance, also in the allocation-free workspace measurement.
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 1.0, SpeedPerSecond: 0.1})
+	assert.NoError(err)
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
+	assert.InDelta(expected.At(0, 1), g.filter.cov.At(_LAT, _LNG), 1e-20)
+	assert.True(mat.EqualApprox(toMeasurement(ob).r, g.measurement(ob).r, 1e-20))
+}
+
+func TestGeoInit(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	})
+	assert.NoError(err)
+	assert.Equal(ErrInvalidPrior, g.Init(nil))
+	assert.Equal(ErrInvalidPrior, g.Init(&GeoEstimated{HorizontalAccuracy: -1.0}))
+	assert.Equal(ErrInvalidPrior, g.Init(&GeoEstimated{Lat: math.NaN(), Lng: -71.0}))
+	assert.Equal(ErrInvalidPrior, g.Init(&GeoEstimated{Lat: 43.0, Lng: math.Inf(1)}))
+	assert.Equal(ErrInvalidPrior, g.Init(&GeoEstimated{Lat: 43.0, Lng: -71.0, HorizontalAccuracy: math.NaN()}))
+	assert.Equal(ErrInvalidPrior, g.Init(&GeoEstimated{Lat: 43.0, Lng: -71.0, HorizontalAccuracy: math.Inf(1)}))
+	assert.Nil(g.Estimate())
+	prior := &GeoEstimated{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Altitude:           100.0,
+		Speed:              10.0,
+		Direction:          45.0,
+		HorizontalAccuracy: 500.0,
+		VerticalAccuracy:   50.0,
+		SpeedAccuracy:      2.0,
+	}
+	assert.NoError(g.Init(prior))
+	e := g.Estimate()
+	assert.InDelta(prior.Lat, e.Lat, 1e-9)
+	assert.InDelta(prior.Lng, e.Lng, 1e-9)
+	assert.InDelta(prior.Altitude, e.Altitude, 1e-9)
+	assert.InDelta(prior.Speed, e.Speed, 1e-9)
+	assert.InDelta(prior.Direction, e.Direction, 1e-9)
+	assert.InDelta(prior.HorizontalAccuracy, e.HorizontalAccuracy, 1e-6)
+	assert.InDelta(prior.VerticalAccuracy, e.VerticalAccuracy, 1e-9)
+	assert.InDelta(prior.SpeedAccuracy, e.SpeedAccuracy, 1e-9)
+
+	// The prior is less accurate than the first fix, which moves the estimate most of the way.
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	_, err = g.Observe(0.0, &GeoObserved{
+		Lat:                43.0 + 100.0/metersPerDegreeLat,
+		Lng:                -71.0,
+		HorizontalAccuracy: 10.0,
+		Components:         GeoPosition,
+	})
+	assert.NoError(err)
+	e = g.Estimate()
+	assert.InDelta(100.0, (e.Lat-43.0)*metersPerDegreeLat, 1.0)
+	assert.True(e.HorizontalAccuracy < 10.0)
+	assert.InDelta(prior.Altitude, e.Altitude, 1e-9)
+
+	// Unknown components get a large variance.
+	assert.NoError(g.Init(&GeoEstimated{Lat: 43.0, Lng: -71.0, HorizontalAccuracy: 10.0}))
+	e = g.Estimate()
+	assert.InDelta(math.Sqrt(unobservedVariance), e.VerticalAccuracy, 1e-9)
+	assert.InDelta(10.0, e.HorizontalAccuracy, 1e-9)
+}
+
+func TestGeoEstimateDirection(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	})
+	assert.NoError(err)
+	_, err = g.Observe(0.0, &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              5.0,
+		SpeedAccuracy:      0.5,
+		Direction:          270.0,
+		DirectionAccuracy:  1.0,
+		HorizontalAccuracy: 10.0,
+		Components:         GeoPosition | GeoVelocity,
+	})
+	assert.NoError(err)
+	e := g.Estimate()
+	assert.InDelta(5.0, e.Speed, 1e-9)
+	assert.InDelta(270.0, e.Direction, 1e-9)
+	assert.InDelta(0.5, e.SpeedAccuracy, 1e-6)
+}
+
+func TestGeoObserveAllocations(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1})
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              5.0,
+		SpeedAccuracy:      0.5,
+		Direction:          30.0,
+		DirectionAccuracy:  5.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   5.0,
+	}
+	_, err = g.Observe(1.0, ob)
+	assert.NoError(err)
+	allocs := testing.AllocsPerRun(100, func() {
+		ob.Lat += 0.00001
+		_, _ = g.Observe(1.0, ob)
+	})
+	assert.Equal(0.0, allocs)
+}
+
+func BenchmarkGeoFilterObserve(b *testing.B) {
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              5.0,
+		SpeedAccuracy:      0.5,
+		Direction:          30.0,
+		DirectionAccuracy:  5.0,
+		HorizontalAccuracy: 10.0,
+		VerticalAccuracy:   5.0,
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.Lat = 43.0 + 0.00001*float64(i%1000)
+		if _, err := g.Observe(1.0, ob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
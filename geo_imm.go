@@ -0,0 +1,55 @@
+package kalman
+
+import (
+	"gonum.org/v1/gonum/mat"
+)
+
+// GeoIMM is the interacting multiple model estimator in geographical coordinates, with a GeoFilter
+// process noise for each mode of motion, such as standing still, walking and driving.
+type GeoIMM struct {
+	imm *IMM
+}
+
+// NewGeoIMM creates and returns a new GeoIMM with a mode for each process noise, see NewIMM for
+// the transition matrix and the initial mode probabilities. The options apply to all the modes,
+// and can't include an innovation gate.
+func NewGeoIMM(modes []*GeoProcessNoise, transition mat.Matrix, prob []float64, opts ...Option) (*GeoIMM, error) {
+	filters := make([]*Filter, len(modes))
+	for i, d := range modes {
+		g, err := NewGeoFilter(d, opts...)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = g.filter
+	}
+	imm, err := NewIMM(filters, transition, prob)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIMM{imm: imm}, nil
+}
+
+// Predict advances the estimated location by td seconds without an observation.
+func (g *GeoIMM) Predict(td float64) error {
+	return g.imm.Predict(td)
+}
+
+// Observe processes a single observation, td is the time since last update, in seconds.
+// The result is the one of the most probable mode after the observation.
+func (g *GeoIMM) Observe(td float64, ob *GeoObserved) (Result, error) {
+	return g.imm.observe(td, toMeasurement(ob))
+}
+
+// Estimate returns the combined location estimate, or nil if nothing was observed yet.
+func (g *GeoIMM) Estimate() *GeoEstimated {
+	c := g.imm.combined()
+	if c == nil {
+		return nil
+	}
+	return geoEstimate(c.state, c.cov)
+}
+
+// Probabilities returns the probability of each mode.
+func (g *GeoIMM) Probabilities() []float64 {
+	return g.imm.Probabilities()
+}
@@ -0,0 +1,46 @@
+package kalman
+
+// NewGeoParticleFilter creates and returns a new particle filter in geographical coordinates, with the particles
+// drawn around the first observation.
+func NewGeoParticleFilter(d *GeoProcessNoise, ob *GeoObserved, params *ParticleParams) (*ParticleFilter, error) {
+	m, err := newGeoMotionModel(d)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFilterWithModel(m)
+	if err != nil {
+		return nil, err
+	}
+	f.initState(toMeasurement(ob))
+	return NewParticleFilter(NewParticleTransition(m), f.state, f.cov, params)
+}
+
+// NewGeoParticleTransition returns the particle transition in geographical coordinates, with the
+// state laid out as in GeoFilter.
+func NewGeoParticleTransition(d *GeoProcessNoise) (ParticleTransitionFunc, error) {
+	m, err := newGeoMotionModel(d)
+	if err != nil {
+		return nil, err
+	}
+	return NewParticleTransition(m), nil
+}
+
+// NewGeoLikelihood returns the log likelihood of the observation, for particles laid out as in GeoFilter.
+func NewGeoLikelihood(ob *GeoObserved) LogLikelihoodFunc {
+	o := toObserved(ob)
+	return NewObservedLikelihood(&o)
+}
+
+// GeoEstimate returns the location estimate from the mean and covariance of the particles,
+// laid out as in GeoFilter.
+func (pf *ParticleFilter) GeoEstimate() *GeoEstimated {
+	e := pf.Estimate()
+	return geoEstimate(e.Mean, e.Cov)
+}
+
+// GeoMAP returns the location of the particle with the largest weight after the last update, with the accuracy
+// computed from the covariance of the particles.
+func (pf *ParticleFilter) GeoMAP() *GeoEstimated {
+	e := pf.Estimate()
+	return geoEstimate(e.MAP, e.Cov)
+}
@@ -0,0 +1,119 @@
+package kalman
+
+import (
+	"fmt"
+)
+
+// TimedGeoObserved is an observation with the time since the previous one.
+type TimedGeoObserved struct {
+	TD float64 // Time since the previous observation, in seconds.
+	GeoObserved
+}
+
+// Smooth processes the recorded observations and returns the smoothed estimates, one for each
+// observation. Each estimate uses all the observations, before and after it. The observations
+// are processed from the current state on a copy of the filter, which doesn't change.
+func (g *GeoFilter) Smooth(obs []TimedGeoObserved) ([]*GeoEstimated, error) {
+	s := NewSmoother(g.filter.clone())
+	for i := range obs {
+		if err := s.observe(obs[i].TD, toMeasurement(&obs[i].GeoObserved)); err != nil {
+			return nil, err
+		}
+	}
+	smoothed, err := rtsSmooth(s.steps)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*GeoEstimated, len(smoothed))
+	for i := range smoothed {
+		res[i] = geoEstimate(smoothed[i].state, smoothed[i].cov)
+	}
+	return res, nil
+}
+
+// ErrInvalidLag is returned when the fixed-lag smoother lag can't be used.
+var ErrInvalidLag = fmt.Errorf("exactly one of lag steps and lag seconds must be positive")
+
+// FixedLag is the delay of the fixed-lag smoother, either in observations or in seconds.
+type FixedLag struct {
+	Steps   int     // Number of later observations to use for smoothing.
+	Seconds float64 // Time span of later observations to use for smoothing, in seconds.
+}
+
+// GeoFixedLagSmoother wraps GeoFilter and emits the smoothed estimates with a fixed delay. Each
+// estimate uses the observations within the lag after it, and only these observations are kept.
+type GeoFixedLagSmoother struct {
+	filter   *GeoFilter
+	smoother *Smoother
+	times    []float64 // Time of each recorded step, since the first one.
+	now      float64   // Time of the last step.
+	lag      FixedLag
+}
+
+// NewGeoFixedLagSmoother creates and returns a new fixed-lag smoother wrapping g.
+func NewGeoFixedLagSmoother(g *GeoFilter, lag FixedLag) (*GeoFixedLagSmoother, error) {
+	if (lag.Steps > 0) == (lag.Seconds > 0) || lag.Steps < 0 || lag.Seconds < 0 {
+		return nil, ErrInvalidLag
+	}
+	return &GeoFixedLagSmoother{
+		filter:   g,
+		smoother: NewSmoother(g.filter),
+		lag:      lag,
+	}, nil
+}
+
+// Observe processes a single observation, td is the time since last update, in seconds.
+// It returns the smoothed estimates for the earlier observations that are now the lag behind,
+// in the order they were observed.
+func (s *GeoFixedLagSmoother) Observe(td float64, ob *GeoObserved) ([]*GeoEstimated, error) {
+	if err := s.smoother.observe(td, toMeasurement(ob)); err != nil {
+		return nil, err
+	}
+	if len(s.times) > 0 {
+		s.now += td
+	}
+	s.times = append(s.times, s.now)
+
+	ready := 0
+	if s.lag.Steps > 0 {
+		ready = len(s.times) - s.lag.Steps
+	} else {
+		for ready < len(s.times) && s.now-s.times[ready] >= s.lag.Seconds {
+			ready++
+		}
+	}
+	if ready <= 0 {
+		return nil, nil
+	}
+	return s.emit(ready)
+}
+
+// Flush returns the smoothed estimates for all the observations that were not emitted yet.
+func (s *GeoFixedLagSmoother) Flush() ([]*GeoEstimated, error) {
+	return s.emit(len(s.times))
+}
+
+// Estimate returns the best location estimate without the delay, same as GeoFilter.Estimate.
+func (s *GeoFixedLagSmoother) Estimate() *GeoEstimated {
+	return s.filter.Estimate()
+}
+
+// emit smooths the recorded steps, returns the estimates for the oldest n of them and forgets them.
+func (s *GeoFixedLagSmoother) emit(n int) ([]*GeoEstimated, error) {
+	smoothed, err := rtsSmooth(s.smoother.steps)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*GeoEstimated, n)
+	for i := 0; i < n; i++ {
+		res[i] = geoEstimate(smoothed[i].state, smoothed[i].cov)
+	}
+	steps := s.smoother.steps
+	k := copy(steps, steps[n:])
+	for i := k; i < len(steps); i++ {
+		steps[i] = smootherStep{}
+	}
+	s.smoother.steps = steps[:k]
+	s.times = s.times[:copy(s.times, s.times[n:])]
+	return res, nil
+}
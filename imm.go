@@ -0,0 +1,292 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidIMM is returned when the interacting multiple model arguments can't be used.
+var ErrInvalidIMM = fmt.Errorf("invalid interacting multiple model arguments")
+
+// IMM is the interacting multiple model estimator. It runs a Filter for each mode of motion,
+// such as standing still, walking and driving, and switches between them following a Markov chain.
+//
+// Before each step, the state of each filter is mixed from the states of all the filters, weighted
+// by the probabilities of switching into its mode. After the observation, the mode probabilities
+// are updated with the likelihood of the observation in each filter.
+type IMM struct {
+	filters    []*Filter
+	transition *mat.Dense // Probability of switching from mode i (row) to mode j (column).
+	prob       []float64  // Mode probabilities.
+	dim        int        // Largest state size, used for the combined estimate.
+	mixed      bool       // The states are already mixed for the next observation.
+}
+
+// NewTransitionMatrix returns the Markov transition matrix for n modes, with the probability
+// of staying in the same mode for one step and the same probability of switching to each of the other modes.
+func NewTransitionMatrix(n int, stay float64) *mat.Dense {
+	m := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i == j {
+				m.Set(i, j, stay)
+			} else {
+				m.Set(i, j, (1.0-stay)/float64(n-1))
+			}
+		}
+	}
+	return m
+}
+
+// NewIMM creates and returns a new IMM estimator with a filter for each mode. The filters may have
+// different motion models and options. Transition is the probability of switching from mode i (row)
+// to mode j (column) in one step, each row must sum to 1. Prob is the initial probability of each mode,
+// if nil, all the modes are equally probable. The filters can't have an innovation gate, the mode
+// probabilities already weigh each observation by its likelihood in each mode.
+func NewIMM(filters []*Filter, transition mat.Matrix, prob []float64) (*IMM, error) {
+	n := len(filters)
+	if n == 0 || !isSquare(transition, n) {
+		return nil, ErrInvalidIMM
+	}
+	if prob == nil {
+		prob = make([]float64, n)
+		for i := range prob {
+			prob[i] = 1.0 / float64(n)
+		}
+	}
+	if len(prob) != n || !isDistribution(prob) {
+		return nil, ErrInvalidIMM
+	}
+	imm := &IMM{
+		transition: mat.DenseCopyOf(transition),
+		prob:       append([]float64(nil), prob...),
+	}
+	for i, f := range filters {
+		if f == nil || f.Initialized() != filters[0].Initialized() || f.opts.gate != nil {
+			return nil, ErrInvalidIMM
+		}
+		row := make([]float64, n)
+		mat.Row(row, i, transition)
+		if !isDistribution(row) {
+			return nil, ErrInvalidIMM
+		}
+		if f.model.Dim() > imm.dim {
+			imm.dim = f.model.Dim()
+		}
+	}
+	imm.filters = append([]*Filter(nil), filters...)
+	return imm, nil
+}
+
+// isDistribution returns true if the probabilities are not negative and sum to 1.
+func isDistribution(p []float64) bool {
+	var sum float64
+	for _, v := range p {
+		if v < 0.0 {
+			return false
+		}
+		sum += v
+	}
+	return math.Abs(sum-1.0) < 1e-9
+}
+
+// Predict advances all the filters by td without a measurement. The states are mixed once
+// per observation, so predicting and then observing with zero td is the same as observing with td.
+func (imm *IMM) Predict(td float64) error {
+	if !imm.Initialized() {
+		return ErrNotInitialized
+	}
+	imm.mix()
+	for _, f := range imm.filters {
+		if err := f.Predict(td); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Observe processes a single observation, td is the time since last update. The result is
+// the one of the most probable mode after the observation.
+func (imm *IMM) Observe(td float64, ob *Observed) (Result, error) {
+	return imm.observe(td, newMeasurement(ob))
+}
+
+// observe processes a single measurement, td is the time since last update.
+func (imm *IMM) observe(td float64, m *measurement) (Result, error) {
+	if !imm.Initialized() {
+		for _, f := range imm.filters {
+			if _, err := f.observe(td, m); err != nil {
+				return Result{}, err
+			}
+		}
+		return accepted, nil
+	}
+	imm.mix()
+	imm.mixed = false
+	logs := make([]float64, len(imm.filters))
+	results := make([]Result, len(imm.filters))
+	maxLog := math.Inf(-1)
+	for j, f := range imm.filters {
+		if err := f.Predict(td); err != nil {
+			return Result{}, err
+		}
+		ll, err := f.logLikelihood(m)
+		if err != nil {
+			return Result{}, err
+		}
+		if results[j], err = f.update(m); err != nil {
+			return Result{}, err
+		}
+		logs[j] = math.Log(imm.prob[j]) + ll
+		if logs[j] > maxLog {
+			maxLog = logs[j]
+		}
+	}
+	if math.IsInf(maxLog, -1) || math.IsNaN(maxLog) {
+		return Result{}, ErrDegenerate
+	}
+	var sum float64
+	best := 0
+	for j := range logs {
+		imm.prob[j] = math.Exp(logs[j] - maxLog)
+		sum += imm.prob[j]
+		if logs[j] > logs[best] {
+			best = j
+		}
+	}
+	for j := range imm.prob {
+		imm.prob[j] /= sum
+	}
+	return results[best], nil
+}
+
+// mix sets the state of each filter to the mixture of the states of all the filters, weighted by
+// the probabilities of switching into its mode, and the mode probabilities to the predicted ones.
+//
+// Components missing from a smaller state keep the values of the filter being mixed into.
+// It does nothing if the states are already mixed for the next observation.
+func (imm *IMM) mix() {
+	if imm.mixed {
+		return
+	}
+	imm.mixed = true
+	n := len(imm.filters)
+	states := make([]gaussian, n)
+	for i, f := range imm.filters {
+		states[i] = f.snapshot()
+	}
+	predicted := make([]float64, n)
+	for j, f := range imm.filters {
+		for i := range imm.filters {
+			predicted[j] += imm.transition.At(i, j) * imm.prob[i]
+		}
+		if predicted[j] == 0.0 {
+			continue
+		}
+		weights := make([]float64, n)
+		for i := range imm.filters {
+			weights[i] = imm.transition.At(i, j) * imm.prob[i] / predicted[j]
+		}
+		x, p := mixture(states, weights, &states[j])
+		f.reset(x, p)
+	}
+	imm.prob = predicted
+}
+
+// mixture returns the mean and covariance of the weighted mixture of the gaussians, of the size of fill.
+// Components missing from a gaussian are taken from fill.
+func mixture(gs []gaussian, weights []float64, fill *gaussian) (*mat.VecDense, *mat.Dense) {
+	n := fill.state.Len()
+	expanded := make([]gaussian, len(gs))
+	x := mat.NewVecDense(n, nil)
+	for i := range gs {
+		expanded[i] = expand(&gs[i], fill)
+		x.AddScaledVec(x, weights[i], expanded[i].state)
+	}
+	p := mat.NewDense(n, n, nil)
+	var d mat.VecDense
+	var spread mat.Dense
+	for i := range gs {
+		if weights[i] == 0.0 {
+			continue
+		}
+		d.SubVec(expanded[i].state, x)
+		spread.Outer(1.0, &d, &d)
+		spread.Add(&spread, expanded[i].cov)
+		spread.Scale(weights[i], &spread)
+		p.Add(p, &spread)
+	}
+	symmetrize(p)
+	return x, p
+}
+
+// expand returns the gaussian resized to the size of fill, with the missing components and their
+// covariance taken from fill, and the extra components dropped.
+func expand(g *gaussian, fill *gaussian) gaussian {
+	n := fill.state.Len()
+	m := g.state.Len()
+	if m == n {
+		return *g
+	}
+	x := mat.NewVecDense(n, nil)
+	p := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i < m && j < m {
+				p.Set(i, j, g.cov.At(i, j))
+			} else if i >= m && j >= m {
+				p.Set(i, j, fill.cov.At(i, j))
+			}
+		}
+		if i < m {
+			x.SetVec(i, g.state.AtVec(i))
+		} else {
+			x.SetVec(i, fill.state.AtVec(i))
+		}
+	}
+	return gaussian{state: x, cov: p}
+}
+
+// Initialized returns true if the filters have processed at least one observation.
+func (imm *IMM) Initialized() bool {
+	return imm.filters[0].Initialized()
+}
+
+// Probabilities returns the probability of each mode.
+func (imm *IMM) Probabilities() []float64 {
+	return append([]float64(nil), imm.prob...)
+}
+
+// Filters returns the filters for each mode.
+func (imm *IMM) Filters() []*Filter {
+	return append([]*Filter(nil), imm.filters...)
+}
+
+// Estimate returns the combined state estimate, or nil if the estimator is not initialized.
+// Components missing from the state of a mode count as zero with zero variance in it.
+func (imm *IMM) Estimate() *Estimated {
+	g := imm.combined()
+	if g == nil {
+		return nil
+	}
+	return g.estimate()
+}
+
+// combined returns the mixture of the filter states weighted by the mode probabilities.
+func (imm *IMM) combined() *gaussian {
+	if !imm.Initialized() {
+		return nil
+	}
+	fill := gaussian{
+		state: mat.NewVecDense(imm.dim, nil),
+		cov:   mat.NewDense(imm.dim, imm.dim, nil),
+	}
+	states := make([]gaussian, len(imm.filters))
+	for i, f := range imm.filters {
+		states[i] = f.gaussian
+	}
+	x, p := mixture(states, imm.prob, &fill)
+	return &gaussian{state: x, cov: p}
+}
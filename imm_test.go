@@ -0,0 +1,217 @@
+package kalman
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/regnull/kalman/geo"
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestIMMSingleMode(t *testing.T) {
+	// With a single mode, IMM is the same as the filter.
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	m, err := NewFilter(d)
+	assert.NoError(err)
+	imm, err := NewIMM([]*Filter{m}, NewTransitionMatrix(1, 1.0), nil)
+	assert.NoError(err)
+	assert.Nil(imm.Estimate())
+	assert.Equal(ErrNotInitialized, imm.Predict(1.0))
+	for i := 0; i < 10; i++ {
+		ob := &Observed{
+			X:          float64(i),
+			Y:          2.0 * float64(i),
+			XA:         1.0,
+			YA:         1.0,
+			ZA:         1.0,
+			Components: ComponentPosition,
+		}
+		expected, err := f.Observe(1.0, ob)
+		assert.NoError(err)
+		res, err := imm.Observe(1.0, ob)
+		assert.NoError(err)
+		assert.Equal(expected.Status, res.Status)
+		assert.InDelta(expected.NIS, res.NIS, 1e-9)
+	}
+	assert.Equal([]float64{1.0}, imm.Probabilities())
+	e := imm.Estimate()
+	assert.InDelta(f.Estimate().X, e.X, 1e-9)
+	assert.InDelta(f.Estimate().VY, e.VY, 1e-9)
+	assert.True(mat.EqualApprox(f.cov, e.Cov, 1e-9))
+}
+
+func TestIMMModels(t *testing.T) {
+	// A constant velocity and a constant acceleration mode, the target moves at constant
+	// velocity first, and then accelerates.
+	assert := assert.New(t)
+	cv, err := NewConstantVelocity(&ProcessNoise{SVX: 0.01, SVY: 0.01, SVZ: 0.01, ST: 1.0})
+	assert.NoError(err)
+	ca, err := NewConstantAcceleration(&ProcessNoise{SVX: 0.01, SVY: 0.01, SVZ: 0.01, SAX: 0.1, SAY: 0.1, SAZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	fcv, err := NewFilterWithModel(cv)
+	assert.NoError(err)
+	fca, err := NewFilterWithModel(ca)
+	assert.NoError(err)
+	imm, err := NewIMM([]*Filter{fcv, fca}, NewTransitionMatrix(2, 0.95), []float64{0.5, 0.5})
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	x, v := 0.0, 1.0
+	for i := 0; i < 100; i++ {
+		a := 0.0
+		if i >= 50 {
+			a = 1.0
+		}
+		x += v + a/2.0
+		v += a
+		_, err := imm.Observe(1.0, &Observed{
+			X:          x + 0.1*rnd.NormFloat64(),
+			XA:         0.1,
+			YA:         0.1,
+			ZA:         0.1,
+			Components: ComponentPosition,
+		})
+		assert.NoError(err)
+		if i == 49 {
+			assert.True(imm.Probabilities()[0] > 0.5, "%v", imm.Probabilities())
+		}
+	}
+	assert.True(imm.Probabilities()[1] > 0.9, "%v", imm.Probabilities())
+	e := imm.Estimate()
+	assert.InDelta(x, e.X, 0.3)
+	assert.InDelta(v, e.VX, 0.3)
+	assert.InDelta(1.0, e.AX, 0.3)
+	assert.Equal(_N+3, e.Cov.RawMatrix().Rows)
+}
+
+func TestIMMPredictThenObserve(t *testing.T) {
+	// Predicting and observing with zero td mixes the states once, same as observing with td.
+	assert := assert.New(t)
+	newIMM := func() *IMM {
+		cv, err := NewConstantVelocity(&ProcessNoise{SVX: 0.01, SVY: 0.01, SVZ: 0.01, ST: 1.0})
+		assert.NoError(err)
+		ca, err := NewConstantAcceleration(&ProcessNoise{SVX: 0.01, SVY: 0.01, SVZ: 0.01, SAX: 0.1, SAY: 0.1, SAZ: 0.1, ST: 1.0})
+		assert.NoError(err)
+		fcv, err := NewFilterWithModel(cv)
+		assert.NoError(err)
+		fca, err := NewFilterWithModel(ca)
+		assert.NoError(err)
+		imm, err := NewIMM([]*Filter{fcv, fca}, NewTransitionMatrix(2, 0.9), nil)
+		assert.NoError(err)
+		return imm
+	}
+	a, b := newIMM(), newIMM()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		ob := &Observed{
+			X:          0.5*float64(i*i) + 0.1*rnd.NormFloat64(),
+			XA:         0.1,
+			YA:         0.1,
+			ZA:         0.1,
+			Components: ComponentPosition,
+		}
+		if i > 0 {
+			assert.NoError(b.Predict(1.0))
+		}
+		_, err := a.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = b.Observe(0.0, ob)
+		assert.NoError(err)
+	}
+	assert.InDeltaSlice(a.Probabilities(), b.Probabilities(), 1e-9)
+	assert.InDelta(a.Estimate().X, b.Estimate().X, 1e-9)
+	assert.True(mat.EqualApprox(a.Estimate().Cov, b.Estimate().Cov, 1e-9))
+}
+
+func TestGeoIMM(t *testing.T) {
+	// The user stands still, then drives, then stands still again. At constant speed all the
+	// modes fit, but the driving mode takes over when the car starts and stops.
+	assert := assert.New(t)
+	modes := []*GeoProcessNoise{
+		{BaseLat: 43.0, DistancePerSecond: 0.01, SpeedPerSecond: 0.01},
+		{BaseLat: 43.0, DistancePerSecond: 0.5, SpeedPerSecond: 0.5},
+		{BaseLat: 43.0, DistancePerSecond: 2.0, SpeedPerSecond: 5.0},
+	}
+	imm, err := NewGeoIMM(modes, NewTransitionMatrix(3, 0.95), nil)
+	assert.NoError(err)
+	still, err := NewGeoFilter(modes[0])
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	x := 0.0
+	var immErr, stillErr, driving float64
+	for i := 0; i < 180; i++ {
+		v := 0.0
+		if i >= 60 && i < 120 {
+			v = 15.0
+		}
+		x += v
+		ob := &GeoObserved{
+			Lat:                43.0 + (x+5.0*rnd.NormFloat64())/metersPerDegreeLat,
+			Lng:                -71.0,
+			HorizontalAccuracy: 5.0,
+			Components:         GeoPosition,
+		}
+		_, err := imm.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = still.Observe(1.0, ob)
+		assert.NoError(err)
+		if i == 59 || i == 179 {
+			assert.True(imm.Probabilities()[0] > 0.5, "%d: %v", i, imm.Probabilities())
+		}
+		if i >= 60 && i < 65 || i >= 120 && i < 125 {
+			driving = math.Max(driving, imm.Probabilities()[2])
+		}
+		if i == 119 {
+			assert.InDelta(15.0, imm.Estimate().Speed, 1.0)
+		}
+		if i >= 60 && i < 120 {
+			d := (imm.Estimate().Lat-43.0)*metersPerDegreeLat - x
+			immErr += d * d
+			d = (still.Estimate().Lat-43.0)*metersPerDegreeLat - x
+			stillErr += d * d
+		}
+	}
+	assert.True(driving > 0.9, "%f", driving)
+	assert.True(math.Sqrt(immErr/60.0) < 10.0)
+	assert.True(immErr < stillErr/10.0, "imm %f, still %f", immErr, stillErr)
+	var sum float64
+	for _, p := range imm.Probabilities() {
+		sum += p
+	}
+	assert.InDelta(1.0, sum, 1e-9)
+}
+
+func TestIMMInvalid(t *testing.T) {
+	assert := assert.New(t)
+	f1, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	f2, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	filters := []*Filter{f1, f2}
+	_, err = NewIMM(nil, NewTransitionMatrix(1, 1.0), nil)
+	assert.Equal(ErrInvalidIMM, err)
+	_, err = NewIMM(filters, NewTransitionMatrix(3, 0.9), nil)
+	assert.Equal(ErrInvalidIMM, err)
+	_, err = NewIMM(filters, mat.NewDense(2, 2, []float64{0.9, 0.2, 0.1, 0.9}), nil)
+	assert.Equal(ErrInvalidIMM, err)
+	_, err = NewIMM(filters, NewTransitionMatrix(2, 0.9), []float64{0.5, 0.6})
+	assert.Equal(ErrInvalidIMM, err)
+	_, err = NewIMM(filters, NewTransitionMatrix(2, 0.9), []float64{1.0})
+	assert.Equal(ErrInvalidIMM, err)
+	gated, err := NewFilter(&ProcessNoise{}, WithInnovationGate(0.99, RejectOutliers))
+	assert.NoError(err)
+	_, err = NewIMM([]*Filter{f1, gated}, NewTransitionMatrix(2, 0.9), nil)
+	assert.Equal(ErrInvalidIMM, err)
+	_, err = NewGeoIMM([]*GeoProcessNoise{{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1}},
+		NewTransitionMatrix(1, 1.0), nil, WithInnovationGate(0.99, RejectOutliers))
+	assert.Equal(ErrInvalidIMM, err)
+	_, err = f1.Observe(0.0, &Observed{})
+	assert.NoError(err)
+	_, err = NewIMM(filters, NewTransitionMatrix(2, 0.9), nil)
+	assert.Equal(ErrInvalidIMM, err)
+}
@@ -0,0 +1,174 @@
+package kalman
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrNotObservable is returned when the information is not sufficient to estimate the state.
+var ErrNotObservable = fmt.Errorf("state is not observable")
+
+// InformationFilter is a Kalman filter in the information form. Instead of the state x and
+// covariance P, it keeps the information matrix Y = P^-1 and the information vector y = P^-1*x.
+//
+// Measurements add to the information, so any number of simultaneous observations are fused
+// without inverting a matrix per observation, and the filter may start with no information
+// at all (diffuse prior).
+type InformationFilter struct {
+	info    *mat.Dense    // Information matrix.
+	infoVec *mat.VecDense // Information vector.
+	model   MotionModel   // Motion model.
+}
+
+// NewInformationFilter creates and returns a new information filter with no prior information.
+func NewInformationFilter(m MotionModel) (*InformationFilter, error) {
+	if m == nil || m.Dim() <= 0 {
+		return nil, ErrInvalidModel
+	}
+	n := m.Dim()
+	return &InformationFilter{
+		info:    mat.NewDense(n, n, nil),
+		infoVec: mat.NewVecDense(n, nil),
+		model:   m,
+	}, nil
+}
+
+// InformationFilter returns an information filter with the state of f.
+func (f *Filter) InformationFilter() (*InformationFilter, error) {
+	if f.state == nil {
+		return nil, ErrNotInitialized
+	}
+	var info mat.Dense
+	if err := info.Inverse(f.cov); err != nil {
+		return nil, err
+	}
+	symmetrize(&info)
+	var infoVec mat.VecDense
+	infoVec.MulVec(&info, f.state)
+	return &InformationFilter{
+		info:    &info,
+		infoVec: &infoVec,
+		model:   f.model,
+	}, nil
+}
+
+// Filter returns a Kalman filter with the state of i. It returns ErrNotObservable
+// if there is not enough information to estimate the state.
+func (i *InformationFilter) Filter(opts ...Option) (*Filter, error) {
+	x, p, err := i.moments()
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFilterWithModel(i.model, opts...)
+	if err != nil {
+		return nil, err
+	}
+	f.reset(x, p)
+	return f, nil
+}
+
+// Predict advances the information by td without a measurement.
+//
+// With M = F^-T*Y*F^-1 and C = (I + M*Q)^-1, the predicted information is Y = C*M and
+// y = C*F^-T*y. This form doesn't require Y or Q to be invertible.
+func (i *InformationFilter) Predict(td float64) error {
+	n := i.model.Dim()
+	f := mat.NewDense(n, n, nil)
+	i.model.Transition(f, td)
+	q := mat.NewDense(n, n, nil)
+	i.model.ProcessNoise(q, td)
+
+	var fi mat.Dense
+	if err := fi.Inverse(f); err != nil {
+		return err
+	}
+	var m mat.Dense
+	m.Mul(fi.T(), i.info)
+	m.Mul(&m, &fi)
+
+	c := mat.DenseCopyOf(eye(n))
+	var mq mat.Dense
+	mq.Mul(&m, q)
+	c.Add(c, &mq)
+	if err := c.Inverse(c); err != nil {
+		return err
+	}
+
+	i.info.Mul(c, &m)
+	symmetrize(i.info)
+	var v mat.VecDense
+	v.MulVec(fi.T(), i.infoVec)
+	i.infoVec.MulVec(c, &v)
+	return nil
+}
+
+// Observe advances the information by td and fuses all the observations, which are
+// taken at the same time.
+func (i *InformationFilter) Observe(td float64, obs ...*Observed) error {
+	if err := i.Predict(td); err != nil {
+		return err
+	}
+	for _, ob := range obs {
+		if err := i.fuse(newMeasurement(ob)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fuse adds the information of the measurement, Y = Y + H^T*R^-1*H and y = y + H^T*R^-1*z.
+func (i *InformationFilter) fuse(m *measurement) error {
+	n := i.model.Dim()
+	m = m.restrict(n)
+	if len(m.idx) == 0 {
+		return nil
+	}
+	h := m.h(n)
+	var ri mat.Dense
+	if err := ri.Inverse(m.r); err != nil {
+		return err
+	}
+	var htri mat.Dense
+	htri.Mul(h.T(), &ri)
+	var info mat.Dense
+	info.Mul(&htri, h)
+	i.info.Add(i.info, &info)
+	var infoVec mat.VecDense
+	infoVec.MulVec(&htri, m.z)
+	i.infoVec.AddVec(i.infoVec, &infoVec)
+	return nil
+}
+
+// Information returns copies of the information matrix and the information vector.
+func (i *InformationFilter) Information() (*mat.Dense, *mat.VecDense) {
+	return mat.DenseCopyOf(i.info), mat.VecDenseCopyOf(i.infoVec)
+}
+
+// Estimate returns the current state estimate, or nil if there is not enough
+// information to estimate the state.
+func (i *InformationFilter) Estimate() *Estimated {
+	x, p, err := i.moments()
+	if err != nil {
+		return nil
+	}
+	g := &gaussian{state: x, cov: p}
+	return g.estimate()
+}
+
+// moments returns the state and covariance, x = Y^-1*y and P = Y^-1.
+func (i *InformationFilter) moments() (*mat.VecDense, *mat.Dense, error) {
+	var chol mat.Cholesky
+	if ok := chol.Factorize(symmetric(i.info)); !ok {
+		return nil, nil, ErrNotObservable
+	}
+	var x mat.VecDense
+	if err := chol.SolveVecTo(&x, i.infoVec); err != nil {
+		return nil, nil, ErrNotObservable
+	}
+	var p mat.SymDense
+	if err := chol.InverseTo(&p); err != nil {
+		return nil, nil, ErrNotObservable
+	}
+	return &x, mat.DenseCopyOf(&p), nil
+}
@@ -0,0 +1,128 @@
+package kalman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestInformationFuseSimultaneous(t *testing.T) {
+	// Simultaneous observations from a diffuse prior add up, no first observation bootstrap.
+	assert := assert.New(t)
+	m, err := NewConstantVelocity(&ProcessNoise{})
+	assert.NoError(err)
+	i, err := NewInformationFilter(m)
+	assert.NoError(err)
+	assert.Nil(i.Estimate())
+
+	ob := &Observed{
+		X:   10.0,
+		Y:   10.0,
+		Z:   10.0,
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.01,
+		VYA: 0.01,
+		VZA: 0.01,
+	}
+	assert.NoError(i.Observe(0.0, ob, ob, ob))
+	e := i.Estimate()
+	assert.NotNil(e)
+	assert.InDelta(10.0, e.X, 1e-9)
+	assert.InDelta(1.0/3.0, e.Cov.At(_X, _X), 1e-9)
+	assert.InDelta(1.0/3.0, e.Cov.At(_Y, _Y), 1e-9)
+	assert.InDelta(1.0/3.0, e.Cov.At(_Z, _Z), 1e-9)
+}
+
+func TestInformationDiffusePrior(t *testing.T) {
+	// Position-only observations make the speed observable only after the second one.
+	assert := assert.New(t)
+	m, err := NewConstantVelocity(&ProcessNoise{})
+	assert.NoError(err)
+	i, err := NewInformationFilter(m)
+	assert.NoError(err)
+	ob := &Observed{
+		X:          0.0,
+		XA:         1.0,
+		YA:         1.0,
+		ZA:         1.0,
+		Components: ComponentPosition,
+	}
+	assert.NoError(i.Observe(0.0, ob))
+	assert.Nil(i.Estimate())
+	_, err = i.Filter()
+	assert.Equal(ErrNotObservable, err)
+
+	ob.X = 10.0
+	assert.NoError(i.Observe(2.0, ob))
+	e := i.Estimate()
+	assert.NotNil(e)
+	assert.InDelta(10.0, e.X, 1e-9)
+	assert.InDelta(5.0, e.VX, 1e-9)
+	assert.InDelta(1.0, e.XA, 1e-9)
+}
+
+func TestInformationMatchesFilter(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  0.5,
+		SZ:  0.1,
+		SVX: 0.1,
+		SVY: 0.2,
+		SVZ: 0.3,
+		ST:  1.0}
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	ob := &Observed{
+		X:   1.0,
+		XA:  1.0,
+		YA:  2.0,
+		ZA:  3.0,
+		VXA: 0.1,
+		VYA: 0.2,
+		VZA: 0.3,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+	i, err := f.InformationFilter()
+	assert.NoError(err)
+	for k := 0; k < 20; k++ {
+		ob.X = float64(k)
+		ob.VX = 1.0
+		ob.Components = 0
+		if k%3 == 0 {
+			ob.Components = ComponentPosition
+		}
+		_, err = f.Observe(1.0, ob)
+		assert.NoError(err)
+		assert.NoError(i.Observe(1.0, ob))
+	}
+	e1 := f.Estimate()
+	e2 := i.Estimate()
+	assert.InDelta(e1.X, e2.X, 1e-9)
+	assert.InDelta(e1.VX, e2.VX, 1e-9)
+	assert.True(mat.EqualApprox(e1.Cov, e2.Cov, 1e-9))
+
+	// Convert back and continue.
+	f2, err := i.Filter()
+	assert.NoError(err)
+	_, err = f.Observe(1.0, ob)
+	assert.NoError(err)
+	_, err = f2.Observe(1.0, ob)
+	assert.NoError(err)
+	assert.True(mat.EqualApprox(f.state, f2.state, 1e-9))
+	assert.True(mat.EqualApprox(f.cov, f2.cov, 1e-9))
+}
+
+func TestInformationNotInitialized(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	_, err = f.InformationFilter()
+	assert.Equal(ErrNotInitialized, err)
+	_, err = NewInformationFilter(nil)
+	assert.Equal(ErrInvalidModel, err)
+}
@@ -0,0 +1,213 @@
+package kalman
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrDimensions is returned when matrix and vector dimensions don't match.
+var ErrDimensions = fmt.Errorf("matrix dimensions mismatch")
+
+// ErrInvalidMeasurementNoise is returned when the measurement noise covariance is not positive definite,
+// as required by the square root filter.
+var ErrInvalidMeasurementNoise = fmt.Errorf("invalid measurement noise")
+
+// LinearModel describes a linear system:
+//
+//	x(k) = F*x(k-1) + B*u(k) + w, w ~ N(0, Q)
+//	z(k) = H*x(k) + v, v ~ N(0, R)
+//
+// The matrices may be changed between the calls to make the model time-varying.
+type LinearModel struct {
+	F mat.Matrix // State transition, n by n.
+	B mat.Matrix // Control, n by l, may be nil if there is no control input.
+	Q mat.Matrix // Process noise covariance, n by n.
+	H mat.Matrix // Measurement, m by n.
+	R mat.Matrix // Measurement noise covariance, m by m.
+}
+
+// LinearFilter is a general linear Kalman filter.
+type LinearFilter struct {
+	gaussian
+	model *LinearModel
+}
+
+// NewLinearFilter creates and returns a new linear Kalman filter with the initial state x and covariance p.
+// The model must have F, Q and H, and R unless it is set before the first update. Only WithUpdateForm
+// and WithSquareRoot apply to the linear filter, the other options return ErrUnsupportedOption.
+func NewLinearFilter(m *LinearModel, x mat.Vector, p mat.Matrix, opts ...Option) (*LinearFilter, error) {
+	if m == nil {
+		return nil, ErrInvalidModel
+	}
+	if x == nil || !isSquare(p, x.Len()) {
+		return nil, ErrDimensions
+	}
+	if m.F == nil || m.Q == nil || m.H == nil {
+		return nil, ErrInvalidModel
+	}
+	n := x.Len()
+	if !isSquare(m.F, n) || !isSquare(m.Q, n) {
+		return nil, ErrDimensions
+	}
+	if _, c := m.H.Dims(); c != n {
+		return nil, ErrDimensions
+	}
+	o := newOptions(opts)
+	if !o.onlyUpdate() {
+		return nil, ErrUnsupportedOption
+	}
+	l := &LinearFilter{
+		gaussian: gaussian{opts: o},
+		model:    m,
+	}
+	l.reset(mat.VecDenseCopyOf(x), mat.DenseCopyOf(p))
+	return l, nil
+}
+
+// Predict advances the state using the control input u, which may be nil. The state doesn't
+// change if the dimensions don't match.
+func (l *LinearFilter) Predict(u mat.Vector) error {
+	n := l.state.Len()
+	if !isSquare(l.model.F, n) || !isSquare(l.model.Q, n) {
+		return ErrDimensions
+	}
+	control := u != nil && l.model.B != nil
+	if control {
+		if r, c := l.model.B.Dims(); r != n || c != u.Len() {
+			return ErrDimensions
+		}
+	}
+	l.predict(l.model.F, l.model.Q)
+	if !control {
+		return nil
+	}
+	var bu mat.VecDense
+	bu.MulVec(l.model.B, u)
+	l.state.AddVec(l.state, &bu)
+	return nil
+}
+
+// Update corrects the state with the measurement z.
+func (l *LinearFilter) Update(z mat.Vector) error {
+	if z == nil || l.model.H == nil {
+		return ErrDimensions
+	}
+	n := l.state.Len()
+	m := z.Len()
+	if r, c := l.model.H.Dims(); r != m || c != n {
+		return ErrDimensions
+	}
+	if !isSquare(l.model.R, m) {
+		return ErrDimensions
+	}
+	return l.correct(z, l.model.H, l.model.R)
+}
+
+// State returns a copy of the current state.
+func (l *LinearFilter) State() *mat.VecDense {
+	return mat.VecDenseCopyOf(l.state)
+}
+
+// Covariance returns a copy of the current state covariance.
+func (l *LinearFilter) Covariance() *mat.Dense {
+	return mat.DenseCopyOf(l.cov)
+}
+
+// predict sets x to F*x and p to F*P*F^T + Q.
+func predict(x *mat.VecDense, p *mat.Dense, f, q mat.Matrix) {
+	var fx mat.VecDense
+	fx.MulVec(f, x)
+	x.CopyVec(&fx)
+
+	var fp mat.Dense
+	fp.Mul(f, p)
+	p.Mul(&fp, f.T())
+	p.Add(p, q)
+	symmetrize(p)
+}
+
+// correct updates x and p with the measurement z = H*x + v, v ~ N(0, R).
+func correct(x *mat.VecDense, p *mat.Dense, z mat.Vector, h, r mat.Matrix, form UpdateForm) error {
+	k, err := kalmanGain(p, h, r)
+	if err != nil {
+		return err
+	}
+
+	var y mat.VecDense
+	y.MulVec(h, x)
+	y.SubVec(z, &y)
+	var ky mat.VecDense
+	ky.MulVec(k, &y)
+	x.AddVec(x, &ky)
+
+	n := x.Len()
+	var kh mat.Dense
+	kh.Mul(k, h)
+	ikh := mat.DenseCopyOf(eye(n))
+	ikh.Sub(ikh, &kh)
+	var pn mat.Dense
+	pn.Mul(ikh, p)
+	if form == JosephForm {
+		pn.Mul(&pn, ikh.T())
+		var kr mat.Dense
+		kr.Mul(k, r)
+		var krk mat.Dense
+		krk.Mul(&kr, k.T())
+		pn.Add(&pn, &krk)
+	}
+	p.Copy(&pn)
+	symmetrize(p)
+	return nil
+}
+
+// symmetrize sets p to (P + P^T)/2, removing the asymmetry caused by rounding errors.
+func symmetrize(p *mat.Dense) {
+	n, _ := p.Dims()
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			v := (p.At(i, j) + p.At(j, i)) / 2.0
+			p.Set(i, j, v)
+			p.Set(j, i, v)
+		}
+	}
+}
+
+// kalmanGain returns the Kalman gain, P*H^T*(H*P*H^T + R)^-1.
+func kalmanGain(p, h, r mat.Matrix) (*mat.Dense, error) {
+	var pht mat.Dense
+	pht.Mul(p, h.T())
+	var s mat.Dense
+	s.Mul(h, &pht)
+	s.Add(&s, r)
+
+	// K^T = S^-1*H*P, solved with Cholesky, which is accurate even when the components have
+	// very different scales.
+	var chol mat.Cholesky
+	if chol.Factorize(symmetric(&s)) {
+		var kt mat.Dense
+		if err := chol.SolveTo(&kt, pht.T()); err != nil {
+			if _, ok := err.(mat.Condition); !ok {
+				return nil, err
+			}
+		}
+		return mat.DenseCopyOf(kt.T()), nil
+	}
+	var si mat.Dense
+	err := si.Inverse(&s)
+	if err != nil {
+		return nil, err
+	}
+	var k mat.Dense
+	k.Mul(&pht, &si)
+	return &k, nil
+}
+
+// isSquare returns true if m is an n by n matrix.
+func isSquare(m mat.Matrix, n int) bool {
+	if m == nil {
+		return false
+	}
+	r, c := m.Dims()
+	return r == n && c == n
+}
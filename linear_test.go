@@ -0,0 +1,170 @@
+package kalman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestLinearScalar(t *testing.T) {
+	// Repeated measurements of a constant reduce the variance as 1/n.
+	assert := assert.New(t)
+	m := &LinearModel{
+		F: mat.NewDense(1, 1, []float64{1.0}),
+		Q: mat.NewDense(1, 1, []float64{0.0}),
+		H: mat.NewDense(1, 1, []float64{1.0}),
+		R: mat.NewDense(1, 1, []float64{4.0}),
+	}
+	l, err := NewLinearFilter(m, mat.NewVecDense(1, []float64{20.0}), mat.NewDense(1, 1, []float64{4.0}))
+	assert.NoError(err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(l.Predict(nil))
+		assert.NoError(l.Update(mat.NewVecDense(1, []float64{24.0})))
+	}
+	assert.InDelta(23.0, l.State().AtVec(0), 1e-9)
+	assert.InDelta(1.0, l.Covariance().At(0, 0), 1e-9)
+}
+
+func TestLinearControl(t *testing.T) {
+	// Battery level drained by a known load.
+	assert := assert.New(t)
+	m := &LinearModel{
+		F: mat.NewDense(1, 1, []float64{1.0}),
+		B: mat.NewDense(1, 1, []float64{-0.5}),
+		Q: mat.NewDense(1, 1, []float64{0.01}),
+		H: mat.NewDense(1, 1, []float64{1.0}),
+		R: mat.NewDense(1, 1, []float64{1.0}),
+	}
+	l, err := NewLinearFilter(m, mat.NewVecDense(1, []float64{100.0}), mat.NewDense(1, 1, []float64{0.01}))
+	assert.NoError(err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(l.Predict(mat.NewVecDense(1, []float64{2.0})))
+	}
+	assert.InDelta(90.0, l.State().AtVec(0), 1e-9)
+	assert.InDelta(0.11, l.Covariance().At(0, 0), 1e-9)
+}
+
+func TestLinearDimensions(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewLinearFilter(&LinearModel{}, mat.NewVecDense(2, nil), mat.NewDense(1, 1, nil))
+	assert.Equal(ErrDimensions, err)
+
+	m := &LinearModel{
+		F: mat.NewDense(2, 2, []float64{1.0, 1.0, 0.0, 1.0}),
+		B: mat.NewDense(2, 1, []float64{0.5, 1.0}),
+		Q: mat.NewDense(2, 2, nil),
+		H: mat.NewDense(1, 2, []float64{1.0, 0.0}),
+		R: mat.NewDense(1, 1, []float64{1.0}),
+	}
+	l, err := NewLinearFilter(m, mat.NewVecDense(2, nil), eye(2))
+	assert.NoError(err)
+	assert.Equal(ErrDimensions, l.Predict(mat.NewVecDense(2, nil)))
+	assert.Equal(ErrDimensions, l.Update(mat.NewVecDense(2, nil)))
+	m.R = mat.NewDense(2, 2, nil)
+	assert.Equal(ErrDimensions, l.Update(mat.NewVecDense(1, nil)))
+
+	// A control input of the wrong size doesn't advance the state.
+	m.B = mat.NewDense(2, 2, nil)
+	l, err = NewLinearFilter(m, mat.NewVecDense(2, []float64{1.0, 1.0}), eye(2))
+	assert.NoError(err)
+	assert.Equal(ErrDimensions, l.Predict(mat.NewVecDense(1, nil)))
+	assert.Equal([]float64{1.0, 1.0}, l.State().RawVector().Data)
+	assert.True(mat.Equal(eye(2), l.Covariance()))
+}
+
+func TestLinearInvalid(t *testing.T) {
+	assert := assert.New(t)
+	x, p := mat.NewVecDense(2, nil), eye(2)
+	m := &LinearModel{
+		F: eye(2),
+		Q: mat.NewDense(2, 2, nil),
+		H: mat.NewDense(1, 2, []float64{1.0, 0.0}),
+		R: mat.NewDense(1, 1, []float64{1.0}),
+	}
+	_, err := NewLinearFilter(nil, x, p)
+	assert.Equal(ErrInvalidModel, err)
+	_, err = NewLinearFilter(m, nil, p)
+	assert.Equal(ErrDimensions, err)
+	_, err = NewLinearFilter(m, x, nil)
+	assert.Equal(ErrDimensions, err)
+	for _, invalid := range []*LinearModel{
+		{Q: m.Q, H: m.H, R: m.R},
+		{F: m.F, H: m.H, R: m.R},
+		{F: m.F, Q: m.Q, R: m.R},
+	} {
+		_, err = NewLinearFilter(invalid, x, p)
+		assert.Equal(ErrInvalidModel, err)
+	}
+	_, err = NewLinearFilter(&LinearModel{F: eye(3), Q: m.Q, H: m.H}, x, p)
+	assert.Equal(ErrDimensions, err)
+	_, err = NewLinearFilter(&LinearModel{F: m.F, Q: m.Q, H: eye(3)}, x, p)
+	assert.Equal(ErrDimensions, err)
+
+	for _, opt := range []Option{WithFixedSize(), WithDiagnostics(10), WithInnovationGate(0.99, RejectOutliers)} {
+		_, err = NewLinearFilter(m, x, p, opt)
+		assert.Equal(ErrUnsupportedOption, err)
+	}
+	_, err = NewLinearFilter(m, x, p, WithUpdateForm(SimpleForm), WithSquareRoot())
+	assert.NoError(err)
+
+	l, err := NewLinearFilter(m, x, p)
+	assert.NoError(err)
+	m.H = nil
+	assert.Equal(ErrDimensions, l.Update(mat.NewVecDense(1, nil)))
+}
+
+func TestLinearMatchesFilter(t *testing.T) {
+	// The linear filter with the constant velocity model gives the same results as Filter.
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  1.0,
+		SZ:  1.0,
+		SVX: 0.1,
+		SVY: 0.1,
+		SVZ: 0.1,
+		ST:  1.0}
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	cv, err := NewConstantVelocity(d)
+	assert.NoError(err)
+
+	ob := &Observed{
+		XA:  1.0,
+		YA:  2.0,
+		ZA:  3.0,
+		VXA: 0.1,
+		VYA: 0.2,
+		VZA: 0.3,
+	}
+	r := mat.NewDense(_N, _N, nil)
+	for i, a := range []float64{ob.XA, ob.YA, ob.ZA, ob.VXA, ob.VYA, ob.VZA} {
+		r.Set(i, i, a*a)
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+	m := &LinearModel{
+		F: mat.NewDense(_N, _N, nil),
+		Q: mat.NewDense(_N, _N, nil),
+		H: eye(_N),
+		R: r,
+	}
+	l, err := NewLinearFilter(m, f.state, f.cov)
+	assert.NoError(err)
+
+	td := 2.0
+	cv.Transition(m.F.(*mat.Dense), td)
+	cv.ProcessNoise(m.Q.(*mat.Dense), td)
+	for i := 0; i < 10; i++ {
+		ob.X = float64(i)
+		ob.Y = 2.0 * float64(i)
+		ob.VX = 0.5
+		_, err = f.Observe(td, ob)
+		assert.NoError(err)
+		assert.NoError(l.Predict(nil))
+		assert.NoError(l.Update(mat.NewVecDense(_N, []float64{ob.X, ob.Y, ob.Z, ob.VX, ob.VY, ob.VZ})))
+	}
+	assert.True(mat.EqualApprox(f.state, l.State(), 1e-9))
+	assert.True(mat.EqualApprox(f.cov, l.Covariance(), 1e-9))
+}
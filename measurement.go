@@ -0,0 +1,187 @@
+package kalman
+
+import (
+	"math"
+	"math/bits"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Component is a bit mask of the observed state components.
+type Component uint8
+
+// Observable state components.
+const (
+	ComponentX Component = 1 << iota
+	ComponentY
+	ComponentZ
+	ComponentVX
+	ComponentVY
+	ComponentVZ
+
+	ComponentPosition = ComponentX | ComponentY | ComponentZ
+	ComponentVelocity = ComponentVX | ComponentVY | ComponentVZ
+	ComponentAll      = ComponentPosition | ComponentVelocity
+)
+
+// unobservedVariance is the variance assigned to state components that were not
+// present in the first observation.
+const unobservedVariance = 1e6
+
+// measurement is the linear measurement model built from a single observation.
+type measurement struct {
+	idx []int         // State indices of the observed components.
+	z   *mat.VecDense // Observed values.
+	r   *mat.Dense    // Measurement noise covariance.
+}
+
+// newMeasurement returns the measurement model for the components present in ob.
+func newMeasurement(ob *Observed) *measurement {
+	m := &measurement{}
+	if k := bits.OnesCount8(uint8(ob.components())); k > 0 {
+		m.z = mat.NewVecDense(k, nil)
+		m.r = mat.NewDense(k, k, nil)
+	}
+	m.set(ob)
+	return m
+}
+
+// set sets the measurement model to the components present in ob, z and r must have
+// the size of the observed components.
+func (m *measurement) set(ob *Observed) {
+	values := [_N]float64{ob.X, ob.Y, ob.Z, ob.VX, ob.VY, ob.VZ}
+	accuracies := [_N]float64{ob.XA, ob.YA, ob.ZA, ob.VXA, ob.VYA, ob.VZA}
+	components := ob.components()
+
+	m.idx = m.idx[:0]
+	for i := 0; i < _N; i++ {
+		if components&(1<<uint(i)) != 0 {
+			m.idx = append(m.idx, i)
+		}
+	}
+	if len(m.idx) == 0 {
+		return
+	}
+	m.r.Zero()
+	for j, i := range m.idx {
+		m.z.SetVec(j, values[i])
+		m.r.Set(j, j, accuracies[i]*accuracies[i])
+	}
+}
+
+// newCorrelatedMeasurement returns the measurement model for the components present in ob,
+// with the full measurement noise covariance.
+func newCorrelatedMeasurement(ob *CorrelatedObserved) (*measurement, error) {
+	m := newMeasurement(&Observed{
+		X:          ob.X,
+		Y:          ob.Y,
+		Z:          ob.Z,
+		VX:         ob.VX,
+		VY:         ob.VY,
+		VZ:         ob.VZ,
+		Components: ob.Components,
+	})
+	if !isSquare(ob.Cov, len(m.idx)) {
+		return nil, ErrDimensions
+	}
+	// The covariance must be symmetric up to rounding errors, and is made exactly symmetric.
+	for j := range m.idx {
+		for l := range m.idx {
+			a, b := ob.Cov.At(j, l), ob.Cov.At(l, j)
+			if !(math.Abs(a-b) <= 1e-12*math.Max(math.Abs(a), math.Abs(b))) {
+				return nil, ErrInvalidMeasurementNoise
+			}
+			m.r.Set(j, l, (a+b)/2.0)
+		}
+	}
+	if len(m.idx) > 0 && !isPSD(m.r) {
+		return nil, ErrInvalidMeasurementNoise
+	}
+	return m, nil
+}
+
+// isPSD returns true if the symmetric matrix is finite and positive semi-definite, allowing
+// for rounding errors.
+func isPSD(m *mat.Dense) bool {
+	if !finite(m.RawMatrix().Data) {
+		return false
+	}
+	var eig mat.EigenSym
+	if !eig.Factorize(symmetric(m), false) {
+		return false
+	}
+	values := eig.Values(nil)
+	return values[0] >= -1e-12*math.Abs(values[len(values)-1])
+}
+
+// finite returns true if none of the values is NaN or infinite.
+func finite(values []float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// setCovariance sets the covariance of the errors of the state components i and k, if both are observed.
+func (m *measurement) setCovariance(i, k int, c float64) {
+	ji, jk := -1, -1
+	for j, s := range m.idx {
+		switch s {
+		case i:
+			ji = j
+		case k:
+			jk = j
+		}
+	}
+	if ji >= 0 && jk >= 0 {
+		m.r.Set(ji, jk, c)
+		m.r.Set(jk, ji, c)
+	}
+}
+
+// h returns the measurement matrix mapping a state of size n to the observed values.
+func (m *measurement) h(n int) *mat.Dense {
+	h := mat.NewDense(len(m.idx), n, nil)
+	for j, i := range m.idx {
+		h.Set(j, i, 1.0)
+	}
+	return h
+}
+
+// components returns the bit mask of the observed components.
+func (m *measurement) components() Component {
+	var c Component
+	for _, i := range m.idx {
+		c |= 1 << uint(i)
+	}
+	return c
+}
+
+// restrict returns the measurement with the components that are outside of the state of size n removed.
+func (m *measurement) restrict(n int) *measurement {
+	k := 0
+	for k < len(m.idx) && m.idx[k] < n {
+		k++
+	}
+	if k == len(m.idx) {
+		return m
+	}
+	if k == 0 {
+		return &measurement{}
+	}
+	return &measurement{
+		idx: m.idx[:k],
+		z:   mat.NewVecDense(k, m.z.RawVector().Data[:k]),
+		r:   mat.DenseCopyOf(m.r.Slice(0, k, 0, k)),
+	}
+}
+
+// components returns the observed components, zero means all of them.
+func (ob *Observed) components() Component {
+	if ob.Components == 0 {
+		return ComponentAll
+	}
+	return ob.Components & ComponentAll
+}
@@ -0,0 +1,159 @@
+package kalman
+
+import (
+	"gonum.org/v1/gonum/mat"
+)
+
+// Symbolic names for the acceleration rows/columns, used by the constant acceleration model.
+const (
+	_AX = 6
+	_AY = 7
+	_AZ = 8
+)
+
+// MotionModel describes how the state evolves over time.
+//
+// The state starts with the coordinates (X, Y, Z), followed by the speed (VX, VY, VZ)
+// and the acceleration (AX, AY, AZ), if the model has them.
+type MotionModel interface {
+	// Dim returns the size of the state vector.
+	Dim() int
+	// Transition sets dst, which is Dim by Dim, to the state transition matrix for time td.
+	Transition(dst *mat.Dense, td float64)
+	// ProcessNoise sets dst, which is Dim by Dim, to the process noise covariance accumulated over time td.
+	ProcessNoise(dst *mat.Dense, td float64)
+}
+
+// ConstantPosition is a motion model where the coordinates follow a random walk.
+type ConstantPosition struct {
+	kinematic
+}
+
+// ConstantVelocity is a motion model where the coordinates and the speed follow a random walk.
+type ConstantVelocity struct {
+	kinematic
+}
+
+// ConstantAcceleration is a motion model where the coordinates, the speed and
+// the acceleration follow a random walk.
+type ConstantAcceleration struct {
+	kinematic
+}
+
+// NewConstantPosition creates and returns a new constant position model, only the coordinates
+// random step is used.
+func NewConstantPosition(d *ProcessNoise) (*ConstantPosition, error) {
+	k, err := newKinematic(0, d)
+	if err != nil {
+		return nil, err
+	}
+	return &ConstantPosition{k}, nil
+}
+
+// NewConstantVelocity creates and returns a new constant velocity model, the acceleration
+// random step is not used.
+func NewConstantVelocity(d *ProcessNoise) (*ConstantVelocity, error) {
+	k, err := newKinematic(1, d)
+	if err != nil {
+		return nil, err
+	}
+	return &ConstantVelocity{k}, nil
+}
+
+// NewConstantAcceleration creates and returns a new constant acceleration model.
+func NewConstantAcceleration(d *ProcessNoise) (*ConstantAcceleration, error) {
+	k, err := newKinematic(2, d)
+	if err != nil {
+		return nil, err
+	}
+	return &ConstantAcceleration{k}, nil
+}
+
+// kinematic is a motion model where each axis is integrated up to the given order
+// (0 is position, 1 is speed, 2 is acceleration) and every derivative is subject to
+// white noise with its own spectral density.
+type kinematic struct {
+	order int
+	q     [3][3]float64 // Spectral densities, by order and axis.
+}
+
+func newKinematic(order int, d *ProcessNoise) (kinematic, error) {
+	steps := [3][3]float64{
+		{d.SX, d.SY, d.SZ},
+		{d.SVX, d.SVY, d.SVZ},
+		{d.SAX, d.SAY, d.SAZ},
+	}
+	k := kinematic{order: order}
+	for i := 0; i <= order; i++ {
+		for a := 0; a < 3; a++ {
+			s := steps[i][a]
+			if d.ST == 0 && s > 0 {
+				return kinematic{}, ErrInvalidProcNoise
+			}
+			if d.ST > 0 {
+				k.q[i][a] = s * s / d.ST
+			}
+		}
+	}
+	return k, nil
+}
+
+// Dim returns the size of the state vector.
+func (k *kinematic) Dim() int {
+	return 3 * (k.order + 1)
+}
+
+// Transition sets dst to the state transition matrix for time td.
+func (k *kinematic) Transition(dst *mat.Dense, td float64) {
+	dst.Zero()
+	for i := 0; i <= k.order; i++ {
+		for j := i; j <= k.order; j++ {
+			v := pow(td, j-i) / factorial(j-i)
+			for a := 0; a < 3; a++ {
+				dst.Set(3*i+a, 3*j+a, v)
+			}
+		}
+	}
+}
+
+// ProcessNoise sets dst to the process noise covariance accumulated over time td.
+// It is the exact discretization of the continuous noise: the noise of every derivative
+// leaks into the lower derivatives (for example, td^3/3 and td^2/2 terms for the speed noise).
+func (k *kinematic) ProcessNoise(dst *mat.Dense, td float64) {
+	dst.Zero()
+	for i := 0; i <= k.order; i++ {
+		for j := 0; j <= k.order; j++ {
+			for a := 0; a < 3; a++ {
+				var v float64
+				for n := max(i, j); n <= k.order; n++ {
+					p := 2*n - i - j + 1
+					v += k.q[n][a] * pow(td, p) / (factorial(n-i) * factorial(n-j) * float64(p))
+				}
+				dst.Set(3*i+a, 3*j+a, v)
+			}
+		}
+	}
+}
+
+func pow(x float64, n int) float64 {
+	r := 1.0
+	for i := 0; i < n; i++ {
+		r *= x
+	}
+	return r
+}
+
+func factorial(n int) float64 {
+	r := 1.0
+	for i := 2; i <= n; i++ {
+		r *= float64(i)
+	}
+	return r
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,156 @@
+package kalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestModelDim(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{}
+	cp, err := NewConstantPosition(d)
+	assert.NoError(err)
+	assert.Equal(3, cp.Dim())
+	cv, err := NewConstantVelocity(d)
+	assert.NoError(err)
+	assert.Equal(6, cv.Dim())
+	ca, err := NewConstantAcceleration(d)
+	assert.NoError(err)
+	assert.Equal(9, ca.Dim())
+
+	_, err = NewConstantAcceleration(&ProcessNoise{SAX: 1.0})
+	assert.Equal(ErrInvalidProcNoise, err)
+	_, err = NewFilterWithModel(nil)
+	assert.Equal(ErrInvalidModel, err)
+}
+
+func TestConstantAccelerationTransition(t *testing.T) {
+	assert := assert.New(t)
+	m, err := NewConstantAcceleration(&ProcessNoise{})
+	assert.NoError(err)
+	td := 2.0
+	f := mat.NewDense(9, 9, nil)
+	m.Transition(f, td)
+	for a := 0; a < 3; a++ {
+		assert.Equal(1.0, f.At(_X+a, _X+a))
+		assert.Equal(td, f.At(_X+a, _VX+a))
+		assert.Equal(td*td/2.0, f.At(_X+a, _AX+a))
+		assert.Equal(1.0, f.At(_VX+a, _VX+a))
+		assert.Equal(td, f.At(_VX+a, _AX+a))
+		assert.Equal(1.0, f.At(_AX+a, _AX+a))
+		assert.Equal(0.0, f.At(_VX+a, _X+a))
+	}
+	assert.Equal(0.0, f.At(_X, _VY))
+}
+
+func TestConstantAccelerationProcessNoise(t *testing.T) {
+	// Check against the closed form of the continuous white noise jerk model.
+	assert := assert.New(t)
+	m, err := NewConstantAcceleration(&ProcessNoise{
+		SAX: 2.0,
+		SAY: 1.0,
+		SAZ: 3.0,
+		ST:  1.0})
+	assert.NoError(err)
+	td := 3.0
+	q := mat.NewDense(9, 9, nil)
+	m.ProcessNoise(q, td)
+	for a, s := range []float64{2.0, 1.0, 3.0} {
+		qa := s * s
+		assert.InDelta(qa*math.Pow(td, 5)/20.0, q.At(_X+a, _X+a), 1e-9)
+		assert.InDelta(qa*math.Pow(td, 4)/8.0, q.At(_X+a, _VX+a), 1e-9)
+		assert.InDelta(qa*math.Pow(td, 3)/6.0, q.At(_X+a, _AX+a), 1e-9)
+		assert.InDelta(qa*math.Pow(td, 3)/3.0, q.At(_VX+a, _VX+a), 1e-9)
+		assert.InDelta(qa*math.Pow(td, 2)/2.0, q.At(_VX+a, _AX+a), 1e-9)
+		assert.InDelta(qa*td, q.At(_AX+a, _AX+a), 1e-9)
+		assert.InDelta(q.At(_AX+a, _X+a), q.At(_X+a, _AX+a), 1e-9)
+	}
+}
+
+func TestConstantPositionIgnoresSpeed(t *testing.T) {
+	assert := assert.New(t)
+	m, err := NewConstantPosition(&ProcessNoise{SX: 1.0, SY: 1.0, SZ: 1.0, ST: 1.0})
+	assert.NoError(err)
+	f, err := NewFilterWithModel(m)
+	assert.NoError(err)
+	ob := &Observed{
+		X:   10.0,
+		Y:   10.0,
+		Z:   10.0,
+		VX:  5.0,
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.01,
+		VYA: 0.01,
+		VZA: 0.01,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+	_, err = f.Observe(10.0, ob)
+	assert.NoError(err)
+	e := f.Estimate()
+	assert.InDelta(10.0, e.X, 1e-9)
+	assert.Equal(0.0, e.VX)
+	assert.Equal(0.0, e.VXA)
+	r, _ := e.Cov.Dims()
+	assert.Equal(3, r)
+}
+
+func TestConstantAccelerationLessLag(t *testing.T) {
+	// Track a braking vehicle, the constant acceleration model must lag less than constant velocity.
+	assert := assert.New(t)
+	cv, err := NewConstantVelocity(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	ca, err := NewConstantAcceleration(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, SAX: 0.1, SAY: 0.1, SAZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+
+	lag := func(m MotionModel) float64 {
+		f, err := NewFilterWithModel(m)
+		assert.NoError(err)
+		a := -2.0
+		v0 := 30.0
+		var x float64
+		for i := 0; i <= 10; i++ {
+			s := float64(i)
+			x = v0*s + a*s*s/2.0
+			_, err = f.Observe(1.0, &Observed{
+				X:          x,
+				VX:         v0 + a*s,
+				XA:         1.0,
+				YA:         1.0,
+				ZA:         1.0,
+				VXA:        0.5,
+				VYA:        0.5,
+				VZA:        0.5,
+				Components: ComponentAll,
+			})
+			assert.NoError(err)
+		}
+		return math.Abs(f.Estimate().X - x)
+	}
+	lagCV := lag(cv)
+	lagCA := lag(ca)
+	assert.True(lagCA < lagCV, "constant acceleration lag %f, constant velocity lag %f", lagCA, lagCV)
+
+	f, err := NewFilterWithModel(ca)
+	assert.NoError(err)
+	for i := 0; i <= 20; i++ {
+		s := float64(i)
+		_, err = f.Observe(1.0, &Observed{
+			X:   30.0*s - s*s,
+			XA:  0.1,
+			YA:  0.1,
+			ZA:  0.1,
+			VXA: 0.1,
+			VYA: 0.1,
+			VZA: 0.1,
+			VX:  30.0 - 2.0*s,
+		})
+		assert.NoError(err)
+	}
+	assert.InDelta(-2.0, f.Estimate().AX, 0.1)
+}
@@ -0,0 +1,224 @@
+package kalman
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidOutOfSequence is returned when the out-of-sequence configuration can't be used.
+var ErrInvalidOutOfSequence = fmt.Errorf("out-of-sequence history and maximum lateness must be positive")
+
+// OutOfSequence configures the handling of observations that arrive out of order.
+type OutOfSequence struct {
+	// History is the number of the most recent observations kept to be re-applied after a late one.
+	History int
+	// MaxLateness is how much an observation may be older than the newest one. Older observations,
+	// and the ones older than the kept history, are dropped.
+	MaxLateness time.Duration
+}
+
+// valid returns true if the configuration can be used.
+func (o *OutOfSequence) valid() bool {
+	return o.History > 0 && o.MaxLateness > 0
+}
+
+// OutOfSequenceFilter wraps Filter and processes timestamped observations that may arrive out
+// of order. On a late observation, the filter is rolled back to the state before it, and the
+// observation is applied followed by the more recent ones.
+//
+// The diagnostics of the wrapped filter record the late observation, but not the re-applied ones.
+type OutOfSequenceFilter struct {
+	reorder
+}
+
+// GeoOutOfSequenceFilter wraps GeoFilter and processes timestamped observations that may arrive
+// out of order, see OutOfSequenceFilter.
+type GeoOutOfSequenceFilter struct {
+	reorder
+	geo *GeoFilter
+}
+
+// NewOutOfSequenceFilter creates and returns a new out-of-sequence filter wrapping f. The filter
+// continues from the last update of f, if known, and drops the observations before it.
+func NewOutOfSequenceFilter(f *Filter, conf OutOfSequence) (*OutOfSequenceFilter, error) {
+	r, err := newReorder(f, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &OutOfSequenceFilter{r}, nil
+}
+
+// NewGeoOutOfSequenceFilter creates and returns a new out-of-sequence filter wrapping g.
+func NewGeoOutOfSequenceFilter(g *GeoFilter, conf OutOfSequence) (*GeoOutOfSequenceFilter, error) {
+	r, err := newReorder(g.filter, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoOutOfSequenceFilter{reorder: r, geo: g}, nil
+}
+
+// ObserveAt processes the observation made at time t. The result status is Dropped if the
+// observation is too late.
+func (o *OutOfSequenceFilter) ObserveAt(t time.Time, ob *Observed) (Result, error) {
+	return o.observeAt(t, newMeasurement(ob))
+}
+
+// Estimate returns the estimate at the time of the newest observation, or nil if the filter
+// is not initialized.
+func (o *OutOfSequenceFilter) Estimate() *Estimated {
+	return o.filter.Estimate()
+}
+
+// ObserveAt processes the observation made at time t. The result status is Dropped if the
+// observation is too late.
+func (o *GeoOutOfSequenceFilter) ObserveAt(t time.Time, ob *GeoObserved) (Result, error) {
+	return o.observeAt(t, toMeasurement(ob))
+}
+
+// Estimate returns the estimate at the time of the newest observation, or nil if the filter
+// is not initialized.
+func (o *GeoOutOfSequenceFilter) Estimate() *GeoEstimated {
+	return o.geo.Estimate()
+}
+
+// reorder keeps the recent observations with the filter state after each of them, to roll back
+// and re-apply them after a late observation.
+type reorder struct {
+	filter  *Filter
+	conf    OutOfSequence
+	base    checkpoint   // State before the oldest kept observation, the time is zero until known.
+	history []checkpoint // Kept observations with the state after each of them, oldest first.
+	dropped int
+}
+
+// checkpoint is the filter state after the observation made at time t.
+type checkpoint struct {
+	t     time.Time
+	m     *measurement
+	state gaussian    // No state if the filter is not initialized.
+	adapt *adaptation // Nil unless the noise is adapted.
+}
+
+func newReorder(f *Filter, conf OutOfSequence) (reorder, error) {
+	if !conf.valid() {
+		return reorder{}, ErrInvalidOutOfSequence
+	}
+	r := reorder{filter: f, conf: conf}
+	r.base = r.checkpoint(f.last, nil)
+	return r, nil
+}
+
+// Dropped returns the number of observations dropped because they were too late.
+func (r *reorder) Dropped() int {
+	return r.dropped
+}
+
+// observeAt processes the measurement made at time t.
+func (r *reorder) observeAt(t time.Time, m *measurement) (Result, error) {
+	n := len(r.history)
+	if n > 0 && r.history[n-1].t.Sub(t) > r.conf.MaxLateness ||
+		r.base.state.state != nil && t.Before(r.base.t) {
+		r.dropped++
+		return Result{Status: Dropped}, nil
+	}
+	if n == 0 || !t.Before(r.history[n-1].t) {
+		return r.apply(t, m)
+	}
+
+	// Roll back to the last observation before t, and re-apply the later ones after the late one.
+	// If that fails, the history and the state before the rollback are restored.
+	saved, base, current := append([]checkpoint(nil), r.history...), r.base, r.checkpoint(r.filter.last, nil)
+	k := sort.Search(n, func(i int) bool { return t.Before(r.history[i].t) })
+	later := saved[k:]
+	r.history = r.history[:k]
+	if k > 0 {
+		r.restore(&r.history[k-1])
+	} else {
+		r.restore(&r.base)
+	}
+	res, err := r.replay(t, m, later)
+	if err != nil {
+		r.history, r.base = saved, base
+		r.restore(&current)
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// replay processes the measurement made at time t, and then re-applies the later observations
+// without recording them in the diagnostics.
+func (r *reorder) replay(t time.Time, m *measurement, later []checkpoint) (Result, error) {
+	res, err := r.apply(t, m)
+	if err != nil {
+		return Result{}, err
+	}
+	diag := r.filter.diag
+	r.filter.diag = nil
+	defer func() { r.filter.diag = diag }()
+	for i := range later {
+		if _, err := r.apply(later[i].t, later[i].m); err != nil {
+			return Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// apply processes the measurement made at time t, after all the kept ones, and records it.
+func (r *reorder) apply(t time.Time, m *measurement) (Result, error) {
+	prev := r.base.t
+	if n := len(r.history); n > 0 {
+		prev = r.history[n-1].t
+	} else if prev.IsZero() {
+		prev = t
+		r.base.t = t
+	}
+	res, err := r.filter.observe(t.Sub(prev).Seconds(), m)
+	if err != nil {
+		return Result{}, err
+	}
+	r.filter.last = t
+	r.history = append(r.history, r.checkpoint(t, m))
+
+	// Keep the history bounded, and only one observation older than the maximum lateness.
+	newest := r.history[len(r.history)-1].t
+	for len(r.history) > r.conf.History ||
+		len(r.history) > 1 && newest.Sub(r.history[1].t) > r.conf.MaxLateness {
+		r.base = r.history[0]
+		r.base.m = nil
+		r.history = r.history[1:]
+	}
+	return res, nil
+}
+
+// checkpoint returns the current filter state for the measurement made at time t.
+func (r *reorder) checkpoint(t time.Time, m *measurement) checkpoint {
+	c := checkpoint{t: t, m: m}
+	if r.filter.state != nil {
+		c.state = r.filter.snapshot()
+	}
+	if r.filter.adapt != nil {
+		c.adapt = r.filter.adapt.copy()
+	}
+	return c
+}
+
+// restore sets the filter state to the checkpoint.
+func (r *reorder) restore(c *checkpoint) {
+	f := r.filter
+	f.last = c.t
+	if c.state.state == nil {
+		f.state, f.cov, f.sqrtCov = nil, nil, nil
+	} else {
+		f.state = mat.VecDenseCopyOf(c.state.state)
+		f.cov = mat.DenseCopyOf(c.state.cov)
+		if c.state.sqrtCov != nil {
+			f.sqrtCov = mat.DenseCopyOf(c.state.sqrtCov)
+		}
+	}
+	if c.adapt != nil {
+		f.adapt = c.adapt.copy()
+	}
+}
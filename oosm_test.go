@@ -0,0 +1,197 @@
+package kalman
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inOrder returns the estimate of the filter fed with the observations at times ts (seconds) in order.
+func inOrder(assert *assert.Assertions, obs []*Observed, ts []float64, opts ...Option) *Estimated {
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, opts...)
+	assert.NoError(err)
+	prev := ts[0]
+	for i := range obs {
+		_, err = f.Observe(ts[i]-prev, obs[i])
+		assert.NoError(err)
+		prev = ts[i]
+	}
+	return f.Estimate()
+}
+
+func TestOutOfSequence(t *testing.T) {
+	assert := assert.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	var obs []*Observed
+	var ts []float64
+	for i := 0; i < 10; i++ {
+		obs = append(obs, randomObserved(rnd, i))
+		ts = append(ts, 0.5*float64(i))
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s float64) time.Time { return start.Add(time.Duration(s * float64(time.Second))) }
+
+	for _, late := range []int{0, 5, 8} {
+		f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, WithDiagnostics(20))
+		assert.NoError(err)
+		o, err := NewOutOfSequenceFilter(f, OutOfSequence{History: 10, MaxLateness: 5 * time.Second})
+		assert.NoError(err)
+		for i := range obs {
+			if i == late {
+				continue
+			}
+			res, err := o.ObserveAt(at(ts[i]), obs[i])
+			assert.NoError(err)
+			assert.Equal(Accepted, res.Status)
+		}
+		res, err := o.ObserveAt(at(ts[late]), obs[late])
+		assert.NoError(err)
+		assert.Equal(Accepted, res.Status)
+		assert.Equal(0, o.Dropped())
+		expected := inOrder(assert, obs, ts)
+		e := o.Estimate()
+		assert.InDelta(expected.X, e.X, 1e-9, "late %d", late)
+		assert.InDelta(expected.VX, e.VX, 1e-9, "late %d", late)
+		assert.InDelta(expected.XA, e.XA, 1e-9, "late %d", late)
+		// The re-applied observations are not recorded again.
+		if late > 0 {
+			assert.Len(f.Diagnostics().NIS(), 9)
+		}
+	}
+}
+
+func TestOutOfSequenceDropped(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	o, err := NewOutOfSequenceFilter(f, OutOfSequence{History: 3, MaxLateness: 10 * time.Second})
+	assert.NoError(err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		_, err = o.ObserveAt(start.Add(time.Duration(i)*time.Second), randomObserved(rnd, i))
+		assert.NoError(err)
+	}
+	e := o.Estimate()
+
+	// Later than the maximum lateness.
+	res, err := o.ObserveAt(start.Add(5*time.Second), randomObserved(rnd, 5))
+	assert.NoError(err)
+	assert.Equal(Dropped, res.Status)
+	// Older than the kept history.
+	res, err = o.ObserveAt(start.Add(15500*time.Millisecond), randomObserved(rnd, 16))
+	assert.NoError(err)
+	assert.Equal(Dropped, res.Status)
+	assert.Equal(2, o.Dropped())
+	assert.Equal(e, o.Estimate())
+
+	// Within the kept history.
+	res, err = o.ObserveAt(start.Add(17500*time.Millisecond), randomObserved(rnd, 17))
+	assert.NoError(err)
+	assert.Equal(Accepted, res.Status)
+	assert.Equal(2, o.Dropped())
+	assert.NotEqual(e.X, o.Estimate().X)
+
+	_, err = NewOutOfSequenceFilter(f, OutOfSequence{History: 0, MaxLateness: time.Second})
+	assert.Equal(ErrInvalidOutOfSequence, err)
+	_, err = NewOutOfSequenceFilter(f, OutOfSequence{History: 1})
+	assert.Equal(ErrInvalidOutOfSequence, err)
+	assert.Equal("dropped", Dropped.String())
+}
+
+func TestOutOfSequenceWrapTimed(t *testing.T) {
+	// The wrapped filter continues from its last update.
+	assert := assert.New(t)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := &Observed{VX: 1.0, XA: 0.1, YA: 0.1, ZA: 0.1, VXA: 0.01, VYA: 0.01, VZA: 0.01}
+	fix := &Observed{X: 10.0, XA: 0.1, YA: 0.1, ZA: 0.1, Components: ComponentPosition}
+	var filters []*Filter
+	for i := 0; i < 2; i++ {
+		f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+		assert.NoError(err)
+		_, err = f.ObserveAt(start, first)
+		assert.NoError(err)
+		filters = append(filters, f)
+	}
+	_, err := filters[0].ObserveAt(start.Add(10*time.Second), fix)
+	assert.NoError(err)
+	o, err := NewOutOfSequenceFilter(filters[1], OutOfSequence{History: 10, MaxLateness: time.Minute})
+	assert.NoError(err)
+
+	// Before the last update of the wrapped filter, there is nothing to roll back to.
+	res, err := o.ObserveAt(start.Add(-time.Second), fix)
+	assert.NoError(err)
+	assert.Equal(Dropped, res.Status)
+
+	res, err = o.ObserveAt(start.Add(10*time.Second), fix)
+	assert.NoError(err)
+	assert.Equal(Accepted, res.Status)
+	assert.Equal(filters[0].Estimate(), o.Estimate())
+	assert.InDelta(10.0, o.Estimate().X, 0.1)
+}
+
+func TestOutOfSequenceReplayError(t *testing.T) {
+	// The square root filter can't use an observation without noise for an update, but it can
+	// start from it. When it has to be re-applied as an update, the rollback is undone.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}, WithSquareRoot())
+	assert.NoError(err)
+	o, err := NewOutOfSequenceFilter(f, OutOfSequence{History: 10, MaxLateness: time.Minute})
+	assert.NoError(err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	exact := &Observed{X: 1.0, Components: ComponentPosition}
+	_, err = o.ObserveAt(start.Add(time.Second), exact)
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 2; i < 5; i++ {
+		_, err = o.ObserveAt(start.Add(time.Duration(i)*time.Second), randomObserved(rnd, i))
+		assert.NoError(err)
+	}
+	e := o.Estimate()
+	history := append([]checkpoint(nil), o.history...)
+
+	_, err = o.ObserveAt(start, randomObserved(rnd, 0))
+	assert.Equal(ErrInvalidMeasurementNoise, err)
+	assert.Equal(e, o.Estimate())
+	assert.Equal(history, o.history)
+	assert.Equal(start.Add(4*time.Second), f.LastUpdate())
+
+	// The following observations are processed as before.
+	_, err = o.ObserveAt(start.Add(5*time.Second), randomObserved(rnd, 5))
+	assert.NoError(err)
+	_, err = o.ObserveAt(start.Add(4500*time.Millisecond), randomObserved(rnd, 4))
+	assert.NoError(err)
+	assert.Len(o.history, 6)
+}
+
+func TestGeoOutOfSequence(t *testing.T) {
+	assert := assert.New(t)
+	d := &GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1}
+	g, err := NewGeoFilter(d)
+	assert.NoError(err)
+	o, err := NewGeoOutOfSequenceFilter(g, OutOfSequence{History: 10, MaxLateness: time.Minute})
+	assert.NoError(err)
+	ref, err := NewGeoFilter(d)
+	assert.NoError(err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var obs []*GeoObserved
+	for i := 0; i < 5; i++ {
+		obs = append(obs, &GeoObserved{
+			Lat:                43.0 + 0.0001*float64(i),
+			Lng:                -71.0,
+			HorizontalAccuracy: 10.0,
+			Components:         GeoPosition,
+		})
+		_, err = ref.Observe(1.0, obs[i])
+		assert.NoError(err)
+	}
+	for _, i := range []int{1, 0, 3, 4, 2} {
+		_, err = o.ObserveAt(start.Add(time.Duration(i)*time.Second), obs[i])
+		assert.NoError(err)
+	}
+	assert.InDelta(ref.Estimate().Lat, o.Estimate().Lat, 1e-12)
+	assert.InDelta(ref.Estimate().HorizontalAccuracy, o.Estimate().HorizontalAccuracy, 1e-9)
+	assert.Equal(0, o.Dropped())
+}
@@ -0,0 +1,130 @@
+package kalman
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrUnsupportedOption is returned when an option doesn't apply to the filter being created.
+var ErrUnsupportedOption = fmt.Errorf("option is not supported by the filter")
+
+// UpdateForm selects how the covariance is updated after a measurement.
+type UpdateForm int
+
+const (
+	// JosephForm updates the covariance as (I-KH)*P*(I-KH)^T + K*R*K^T, which keeps it
+	// symmetric and positive definite in the presence of rounding errors.
+	JosephForm UpdateForm = iota
+	// SimpleForm updates the covariance as (I-KH)*P, which is cheaper but may lose
+	// positive definiteness after many updates with very accurate measurements.
+	SimpleForm
+)
+
+// Option configures a filter.
+type Option func(*options)
+
+// options contains the filter configuration.
+type options struct {
+	updateForm   UpdateForm
+	squareRoot   bool
+	gate         *gate
+	diagnostics  bool
+	window       int
+	adaptive     *AdaptiveNoise
+	initState    mat.Vector
+	initCov      mat.Matrix
+	diffusePrior bool
+	diffuse      float64
+	fixedSize    bool
+}
+
+// WithUpdateForm sets the covariance update form, JosephForm is used by default.
+func WithUpdateForm(u UpdateForm) Option {
+	return func(o *options) {
+		o.updateForm = u
+	}
+}
+
+// WithSquareRoot makes the filter propagate a square root (Cholesky factor) of the covariance
+// instead of the covariance itself, which doubles the numerical precision for long sessions.
+// The square root filter requires all measurement accuracies to be non-zero.
+func WithSquareRoot() Option {
+	return func(o *options) {
+		o.squareRoot = true
+	}
+}
+
+// WithInnovationGate makes Filter and GeoFilter check each observation against the chi-square
+// gate that consistent observations pass with the given probability, such as 0.999. The action
+// tells what to do with the observations outside of the gate.
+func WithInnovationGate(probability float64, action GateAction) Option {
+	return func(o *options) {
+		o.gate = newGate(probability, action)
+	}
+}
+
+// WithDiagnostics makes Filter and GeoFilter record the consistency statistics over the last
+// window updates, see Diagnostics.
+func WithDiagnostics(window int) Option {
+	return func(o *options) {
+		o.diagnostics = true
+		o.window = window
+	}
+}
+
+// WithAdaptiveNoise makes Filter and GeoFilter scale the process and measurement noise online,
+// following the recent observations, see AdaptiveNoise.
+func WithAdaptiveNoise(a AdaptiveNoise) Option {
+	return func(o *options) {
+		o.adaptive = &a
+	}
+}
+
+// WithInitialState makes the filter start from the given state and covariance, instead of
+// the first observation.
+func WithInitialState(x mat.Vector, p mat.Matrix) Option {
+	return func(o *options) {
+		o.initState = x
+		o.initCov = p
+		o.diffusePrior = false
+		o.diffuse = 0.0
+	}
+}
+
+// WithDiffusePrior makes the filter start from the zero state with the given variance of all
+// the components, instead of the first observation. With a large variance, the first observation
+// determines the estimate, but it is processed like all the others. The variance must be positive.
+func WithDiffusePrior(variance float64) Option {
+	return func(o *options) {
+		o.initState = nil
+		o.initCov = nil
+		o.diffusePrior = true
+		o.diffuse = variance
+	}
+}
+
+// WithFixedSize makes the filter do the computations on fixed-size 6 by 6 arrays instead of gonum
+// matrices, which is faster. It requires a motion model with the state of size 6, such as
+// the default constant velocity model, and can't be used together with WithSquareRoot.
+func WithFixedSize() Option {
+	return func(o *options) {
+		o.fixedSize = true
+	}
+}
+
+// newOptions returns the configuration with the options applied.
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// onlyUpdate returns true if the configuration only sets the update form and the square root
+// propagation, the options supported by all the filters.
+func (o *options) onlyUpdate() bool {
+	return o.gate == nil && !o.diagnostics && o.adaptive == nil && o.initState == nil &&
+		o.initCov == nil && !o.diffusePrior && !o.fixedSize
+}
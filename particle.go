@@ -0,0 +1,271 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrDegenerate is returned when none of the particles can explain the observation.
+var ErrDegenerate = fmt.Errorf("all particles have zero likelihood")
+
+// ErrInvalidParticleParams is returned when the particle filter parameters can't be used.
+var ErrInvalidParticleParams = fmt.Errorf("invalid particle filter parameters")
+
+// Resampling is the particle resampling scheme.
+type Resampling int
+
+const (
+	// SystematicResampling uses a single random offset for evenly spaced positions.
+	SystematicResampling Resampling = iota
+	// StratifiedResampling uses an independent random position within each of the evenly spaced strata.
+	StratifiedResampling
+)
+
+// defaultResampleThreshold is the default fraction of particles below which the effective sample size
+// triggers resampling.
+const defaultResampleThreshold = 0.5
+
+// ParticleParams configures the particle filter.
+type ParticleParams struct {
+	Particles  int        // Number of particles.
+	Resampling Resampling // Resampling scheme.
+	// Threshold triggers resampling when the effective sample size falls below Threshold*Particles.
+	// Zero means 0.5, 1 resamples after every update.
+	Threshold float64
+	Seed      int64 // Random seed, the filter is deterministic for the given seed.
+}
+
+// ParticleTransitionFunc moves the particle x forward by td, in place, including the random process noise.
+type ParticleTransitionFunc func(x []float64, td float64, rnd *rand.Rand)
+
+// LogLikelihoodFunc returns the log likelihood of an observation for the particle x, up to a constant.
+type LogLikelihoodFunc func(x []float64) float64
+
+// ParticleEstimated is the summary of the particle distribution.
+type ParticleEstimated struct {
+	Mean *mat.VecDense // Weighted mean.
+	Cov  *mat.Dense    // Weighted covariance.
+	// MAP is the particle with the largest weight after the last update, kept through resampling,
+	// the maximum a posteriori estimate.
+	MAP *mat.VecDense
+}
+
+// ParticleFilter is a sequential Monte Carlo filter, which represents the state distribution with a set of
+// weighted particles. Unlike the Kalman filters, it can represent multimodal and non-Gaussian distributions.
+type ParticleFilter struct {
+	particles  [][]float64
+	weights    []float64
+	spare      [][]float64 // Resampling buffer.
+	best       int         // Index of the particle with the largest weight after the last update.
+	transition ParticleTransitionFunc
+	params     ParticleParams
+	rnd        *rand.Rand
+}
+
+// NewParticleFilter creates and returns a new particle filter with the particles drawn from the
+// normal distribution with mean x and covariance p.
+func NewParticleFilter(transition ParticleTransitionFunc, x mat.Vector, p mat.Matrix, params *ParticleParams) (*ParticleFilter, error) {
+	if transition == nil || params == nil || params.Particles <= 0 || params.Threshold < 0 || params.Threshold > 1 {
+		return nil, ErrInvalidParticleParams
+	}
+	if x == nil {
+		return nil, ErrDimensions
+	}
+	n := x.Len()
+	if !isSquare(p, n) {
+		return nil, ErrDimensions
+	}
+	pf := &ParticleFilter{
+		particles:  make([][]float64, params.Particles),
+		weights:    make([]float64, params.Particles),
+		spare:      make([][]float64, params.Particles),
+		transition: transition,
+		params:     *params,
+		rnd:        rand.New(rand.NewSource(params.Seed)),
+	}
+	if pf.params.Threshold == 0 {
+		pf.params.Threshold = defaultResampleThreshold
+	}
+	s := sqrtPSD(p)
+	noise := mat.NewVecDense(n, nil)
+	for i := range pf.particles {
+		for j := 0; j < n; j++ {
+			noise.SetVec(j, pf.rnd.NormFloat64())
+		}
+		var v mat.VecDense
+		v.MulVec(s, noise)
+		v.AddVec(&v, x)
+		pf.particles[i] = v.RawVector().Data
+		pf.spare[i] = make([]float64, n)
+		pf.weights[i] = 1.0 / float64(params.Particles)
+	}
+	return pf, nil
+}
+
+// NewParticleTransition returns the particle transition for the motion model, with the
+// process noise drawn from the normal distribution. The transition reuses its storage under
+// a lock, so it can be shared between filters and used concurrently.
+func NewParticleTransition(m MotionModel) ParticleTransitionFunc {
+	var mu sync.Mutex
+	n := m.Dim()
+	f := mat.NewDense(n, n, nil)
+	q := mat.NewDense(n, n, nil)
+	var s *mat.Dense
+	lastTD := math.NaN()
+	x := mat.NewVecDense(n, nil)
+	noise := mat.NewVecDense(n, nil)
+	var r mat.VecDense
+	return func(p []float64, td float64, rnd *rand.Rand) {
+		mu.Lock()
+		defer mu.Unlock()
+		if td != lastTD {
+			m.Transition(f, td)
+			m.ProcessNoise(q, td)
+			s = sqrtPSD(q)
+			lastTD = td
+		}
+		copy(x.RawVector().Data, p)
+		for j := 0; j < n; j++ {
+			noise.SetVec(j, rnd.NormFloat64())
+		}
+		r.MulVec(s, noise)
+		x.MulVec(f, x)
+		x.AddVec(x, &r)
+		copy(p, x.RawVector().Data)
+	}
+}
+
+// NewObservedLikelihood returns the log likelihood of the observation, assuming independent normal
+// errors with the observation accuracies. Components with zero accuracy are ignored.
+func NewObservedLikelihood(ob *Observed) LogLikelihoodFunc {
+	m := newMeasurement(ob)
+	return func(x []float64) float64 {
+		var ll float64
+		for j, i := range m.idx {
+			v := m.r.At(j, j)
+			if i >= len(x) || v == 0 {
+				continue
+			}
+			d := x[i] - m.z.AtVec(j)
+			ll -= 0.5 * d * d / v
+		}
+		return ll
+	}
+}
+
+// Predict moves all particles forward by td.
+func (pf *ParticleFilter) Predict(td float64) {
+	for _, p := range pf.particles {
+		pf.transition(p, td, pf.rnd)
+	}
+}
+
+// Update weights the particles with the log likelihood of an observation, and resamples them
+// if the effective sample size is too small.
+func (pf *ParticleFilter) Update(ll LogLikelihoodFunc) error {
+	maxLog := math.Inf(-1)
+	logs := make([]float64, len(pf.weights))
+	for i, p := range pf.particles {
+		logs[i] = math.Log(pf.weights[i]) + ll(p)
+		if logs[i] > maxLog {
+			maxLog = logs[i]
+		}
+	}
+	if math.IsInf(maxLog, -1) || math.IsNaN(maxLog) {
+		return ErrDegenerate
+	}
+	var sum float64
+	for i := range logs {
+		pf.weights[i] = math.Exp(logs[i] - maxLog)
+		sum += pf.weights[i]
+	}
+	pf.best = 0
+	for i := range pf.weights {
+		pf.weights[i] /= sum
+		if pf.weights[i] > pf.weights[pf.best] {
+			pf.best = i
+		}
+	}
+	if pf.EffectiveSampleSize() < pf.params.Threshold*float64(len(pf.particles)) {
+		pf.Resample()
+	}
+	return nil
+}
+
+// Observe moves the particles forward by td and weights them with the log likelihood of an observation.
+func (pf *ParticleFilter) Observe(td float64, ll LogLikelihoodFunc) error {
+	pf.Predict(td)
+	return pf.Update(ll)
+}
+
+// EffectiveSampleSize returns the effective number of particles, 1/sum(w^2).
+func (pf *ParticleFilter) EffectiveSampleSize() float64 {
+	var s float64
+	for _, w := range pf.weights {
+		s += w * w
+	}
+	return 1.0 / s
+}
+
+// Resample draws a new set of equally weighted particles in proportion to the weights. The copy
+// of the particle with the largest weight remains the MAP estimate.
+func (pf *ParticleFilter) Resample() {
+	n := len(pf.particles)
+	step := 1.0 / float64(n)
+	offset := pf.rnd.Float64() * step
+	cumulative := pf.weights[0]
+	j := 0
+	best, bestWeight := 0, -1.0
+	for i := 0; i < n; i++ {
+		u := offset + float64(i)*step
+		if pf.params.Resampling == StratifiedResampling {
+			u = (float64(i) + pf.rnd.Float64()) * step
+		}
+		for u > cumulative && j < n-1 {
+			j++
+			cumulative += pf.weights[j]
+		}
+		copy(pf.spare[i], pf.particles[j])
+		if pf.weights[j] > bestWeight {
+			best, bestWeight = i, pf.weights[j]
+		}
+	}
+	pf.particles, pf.spare = pf.spare, pf.particles
+	pf.best = best
+	for i := range pf.weights {
+		pf.weights[i] = step
+	}
+}
+
+// Particles returns copies of the particles and their weights.
+func (pf *ParticleFilter) Particles() ([][]float64, []float64) {
+	particles := make([][]float64, len(pf.particles))
+	for i, p := range pf.particles {
+		particles[i] = append([]float64(nil), p...)
+	}
+	return particles, append([]float64(nil), pf.weights...)
+}
+
+// Estimate returns the summary of the particle distribution.
+func (pf *ParticleFilter) Estimate() *ParticleEstimated {
+	n := len(pf.particles[0])
+	mean := mat.NewVecDense(n, nil)
+	for i, p := range pf.particles {
+		mean.AddScaledVec(mean, pf.weights[i], mat.NewVecDense(n, p))
+	}
+	cov := mat.NewDense(n, n, nil)
+	var d mat.VecDense
+	for i, p := range pf.particles {
+		d.SubVec(mat.NewVecDense(n, p), mean)
+		cov.RankOne(cov, pf.weights[i], &d, &d)
+	}
+	return &ParticleEstimated{
+		Mean: mean,
+		Cov:  cov,
+		MAP:  mat.NewVecDense(n, append([]float64(nil), pf.particles[pf.best]...)),
+	}
+}
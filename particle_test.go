@@ -0,0 +1,271 @@
+package kalman
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/regnull/kalman/geo"
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestParticleDeterministic(t *testing.T) {
+	assert := assert.New(t)
+	m, err := NewConstantVelocity(&ProcessNoise{SX: 1.0, SY: 1.0, SZ: 1.0, SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	run := func(seed int64) *ParticleEstimated {
+		pf, err := NewParticleFilter(NewParticleTransition(m), mat.NewVecDense(_N, nil), eye(_N), &ParticleParams{
+			Particles: 200,
+			Seed:      seed,
+		})
+		assert.NoError(err)
+		for i := 0; i < 5; i++ {
+			assert.NoError(pf.Observe(1.0, NewObservedLikelihood(&Observed{
+				X:          float64(i),
+				XA:         1.0,
+				YA:         1.0,
+				ZA:         1.0,
+				Components: ComponentPosition,
+			})))
+		}
+		return pf.Estimate()
+	}
+	e1 := run(1)
+	e2 := run(1)
+	e3 := run(2)
+	assert.True(mat.Equal(e1.Mean, e2.Mean))
+	assert.True(mat.Equal(e1.Cov, e2.Cov))
+	assert.True(mat.Equal(e1.MAP, e2.MAP))
+	assert.False(mat.Equal(e1.Mean, e3.Mean))
+}
+
+func TestParticleSharedTransition(t *testing.T) {
+	// Filters sharing a transition, used concurrently with different time steps, give the same
+	// results as with a transition each.
+	assert := assert.New(t)
+	m, err := NewConstantVelocity(&ProcessNoise{SX: 1.0, SY: 1.0, SZ: 1.0, SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	run := func(transition ParticleTransitionFunc, td float64) *ParticleEstimated {
+		pf, err := NewParticleFilter(transition, mat.NewVecDense(_N, nil), eye(_N), &ParticleParams{
+			Particles: 200,
+			Seed:      1,
+		})
+		assert.NoError(err)
+		for i := 0; i < 20; i++ {
+			pf.Predict(td)
+		}
+		return pf.Estimate()
+	}
+	shared := NewParticleTransition(m)
+	tds := []float64{0.5, 1.0, 2.0, 3.0}
+	results := make([]*ParticleEstimated, len(tds))
+	var wg sync.WaitGroup
+	for i := range tds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = run(shared, tds[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, td := range tds {
+		e := run(NewParticleTransition(m), td)
+		assert.True(mat.Equal(e.Mean, results[i].Mean), "td %f", td)
+		assert.True(mat.Equal(e.Cov, results[i].Cov), "td %f", td)
+	}
+}
+
+func TestParticleMatchesFilter(t *testing.T) {
+	// On a linear Gaussian problem, the particles approximate the Kalman filter.
+	assert := assert.New(t)
+	d := &ProcessNoise{SX: 0.5, SY: 0.5, SZ: 0.5, SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	m, err := NewConstantVelocity(d)
+	assert.NoError(err)
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	ob := &Observed{
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.5,
+		VYA: 0.5,
+		VZA: 0.5,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+	pf, err := NewParticleFilter(NewParticleTransition(m), f.state, f.cov, &ParticleParams{
+		Particles: 20000,
+		Seed:      1,
+	})
+	assert.NoError(err)
+	for i := 1; i <= 5; i++ {
+		ob.X = float64(i)
+		ob.Y = -float64(i)
+		ob.VX = 1.0
+		ob.VY = -1.0
+		_, err = f.Observe(1.0, ob)
+		assert.NoError(err)
+		assert.NoError(pf.Observe(1.0, NewObservedLikelihood(ob)))
+	}
+	e := pf.Estimate()
+	for i := 0; i < _N; i++ {
+		sigma := math.Sqrt(f.cov.At(i, i))
+		assert.InDelta(f.state.AtVec(i), e.Mean.AtVec(i), 0.25*sigma)
+		assert.InDelta(sigma, math.Sqrt(e.Cov.At(i, i)), 0.25*sigma)
+	}
+}
+
+func TestParticleMultimodal(t *testing.T) {
+	// Two parallel roads at Y = -10 and Y = 10, the observation can't tell which one.
+	assert := assert.New(t)
+	m, err := NewConstantPosition(&ProcessNoise{SX: 0.1, SY: 0.1, SZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	pf, err := NewParticleFilter(NewParticleTransition(m), mat.NewVecDense(3, nil), mat.NewDiagDense(3, []float64{400.0, 400.0, 1.0}), &ParticleParams{
+		Particles: 2000,
+		Seed:      1,
+	})
+	assert.NoError(err)
+	roads := func(x []float64) float64 {
+		d1 := (x[_Y] - 10.0) / 1.0
+		d2 := (x[_Y] + 10.0) / 1.0
+		return math.Log(math.Exp(-0.5*d1*d1) + math.Exp(-0.5*d2*d2))
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(pf.Observe(1.0, roads))
+	}
+	e := pf.Estimate()
+	// The mean is between the roads, the spread covers both of them and the MAP is on one of them.
+	assert.InDelta(0.0, e.Mean.AtVec(_Y), 3.0)
+	assert.InDelta(10.0, math.Sqrt(e.Cov.At(_Y, _Y)), 1.0)
+	assert.InDelta(10.0, math.Abs(e.MAP.AtVec(_Y)), 2.0)
+
+	particles, _ := pf.Particles()
+	var left, right int
+	for _, p := range particles {
+		if math.Abs(p[_Y]+10.0) < 3.0 {
+			left++
+		}
+		if math.Abs(p[_Y]-10.0) < 3.0 {
+			right++
+		}
+	}
+	assert.True(left > 500)
+	assert.True(right > 500)
+}
+
+func TestParticleResampling(t *testing.T) {
+	assert := assert.New(t)
+	for _, r := range []Resampling{SystematicResampling, StratifiedResampling} {
+		m, err := NewConstantPosition(&ProcessNoise{})
+		assert.NoError(err)
+		pf, err := NewParticleFilter(NewParticleTransition(m), mat.NewVecDense(3, nil), eye(3), &ParticleParams{
+			Particles:  1000,
+			Resampling: r,
+			Threshold:  1e-9, // Never resample automatically.
+			Seed:       1,
+		})
+		assert.NoError(err)
+		// Keep 1/4 of the weight on the particles with X < 0.
+		assert.NoError(pf.Update(func(x []float64) float64 {
+			if x[_X] < 0 {
+				return 0.0
+			}
+			return math.Log(3.0)
+		}))
+		assert.True(pf.EffectiveSampleSize() < 1000.0)
+		var before float64
+		particles, weights := pf.Particles()
+		for i, p := range particles {
+			if p[_X] < 0 {
+				before += weights[i]
+			}
+		}
+		pf.Resample()
+		assert.InDelta(1000.0, pf.EffectiveSampleSize(), 1e-6)
+		particles, _ = pf.Particles()
+		var after float64
+		for _, p := range particles {
+			if p[_X] < 0 {
+				after += 1.0 / 1000.0
+			}
+		}
+		assert.InDelta(before, after, 0.01)
+	}
+}
+
+func TestParticleMAP(t *testing.T) {
+	// The MAP is the particle with the largest weight, even after resampling made the weights equal.
+	assert := assert.New(t)
+	for _, r := range []Resampling{SystematicResampling, StratifiedResampling} {
+		m, err := NewConstantPosition(&ProcessNoise{})
+		assert.NoError(err)
+		pf, err := NewParticleFilter(NewParticleTransition(m), mat.NewVecDense(3, nil), mat.NewDiagDense(3, []float64{100.0, 100.0, 100.0}), &ParticleParams{
+			Particles:  1000,
+			Resampling: r,
+			Threshold:  1.0, // Resample after every update.
+			Seed:       1,
+		})
+		assert.NoError(err)
+		particles, _ := pf.Particles()
+		closest := particles[0]
+		for _, p := range particles {
+			if math.Abs(p[_X]-5.0) < math.Abs(closest[_X]-5.0) {
+				closest = p
+			}
+		}
+		assert.NoError(pf.Update(func(x []float64) float64 {
+			d := (x[_X] - 5.0) / 0.1
+			return -0.5 * d * d
+		}))
+		_, weights := pf.Particles()
+		assert.Equal(weights[0], weights[len(weights)-1])
+		assert.Equal(closest, pf.Estimate().MAP.RawVector().Data)
+	}
+}
+
+func TestParticleDegenerate(t *testing.T) {
+	assert := assert.New(t)
+	m, err := NewConstantPosition(&ProcessNoise{})
+	assert.NoError(err)
+	pf, err := NewParticleFilter(NewParticleTransition(m), mat.NewVecDense(3, nil), eye(3), &ParticleParams{Particles: 10})
+	assert.NoError(err)
+	assert.Equal(ErrDegenerate, pf.Update(func(x []float64) float64 {
+		return math.Inf(-1)
+	}))
+	_, err = NewParticleFilter(NewParticleTransition(m), mat.NewVecDense(3, nil), eye(3), &ParticleParams{})
+	assert.Equal(ErrInvalidParticleParams, err)
+	_, err = NewParticleFilter(NewParticleTransition(m), nil, eye(3), &ParticleParams{Particles: 10})
+	assert.Equal(ErrDimensions, err)
+}
+
+func TestGeoParticle(t *testing.T) {
+	assert := assert.New(t)
+	d := &GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 1.0,
+		SpeedPerSecond:    0.1,
+	}
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Altitude:           100.0,
+		SpeedAccuracy:      0.5,
+		DirectionAccuracy:  10.0,
+		HorizontalAccuracy: 20.0,
+		VerticalAccuracy:   10.0,
+	}
+	pf, err := NewGeoParticleFilter(d, ob, &ParticleParams{Particles: 2000, Seed: 1})
+	assert.NoError(err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(pf.Observe(1.0, NewGeoLikelihood(ob)))
+	}
+	e := pf.GeoEstimate()
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	assert.InDelta(43.0, e.Lat, 5.0/metersPerDegreeLat)
+	assert.InDelta(100.0, e.Altitude, 5.0)
+	assert.True(e.HorizontalAccuracy < 20.0)
+	mp := pf.GeoMAP()
+	assert.InDelta(43.0, mp.Lat, 10.0/metersPerDegreeLat)
+	assert.InDelta(e.HorizontalAccuracy, mp.HorizontalAccuracy, 0.01)
+}
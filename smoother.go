@@ -0,0 +1,142 @@
+package kalman
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidCovariance is returned when a covariance is not positive definite.
+var ErrInvalidCovariance = fmt.Errorf("covariance is not positive definite")
+
+// Smoother records the steps of a Filter and computes the Rauch-Tung-Striebel smoothed
+// estimates, which use all the observations, including the ones after each step.
+type Smoother struct {
+	filter *Filter
+	steps  []smootherStep
+}
+
+// smootherStep is a single recorded step of the filter.
+type smootherStep struct {
+	transition *mat.Dense // Transition from the previous step, nil for the first one.
+	predicted  gaussian   // State before the observation.
+	filtered   gaussian   // State after the observation.
+}
+
+// NewSmoother creates and returns a new smoother, recording the steps of f.
+func NewSmoother(f *Filter) *Smoother {
+	return &Smoother{filter: f}
+}
+
+// Predict advances the filter by td without a measurement, and records the step.
+func (s *Smoother) Predict(td float64) error {
+	if err := s.filter.Predict(td); err != nil {
+		return err
+	}
+	s.steps = append(s.steps, smootherStep{
+		transition: s.filter.transition(td),
+		predicted:  s.filter.snapshot(),
+		filtered:   s.filter.snapshot(),
+	})
+	return nil
+}
+
+// Observe processes a single observation with the filter, td is the time since last update,
+// and records the step.
+func (s *Smoother) Observe(td float64, ob *Observed) error {
+	return s.observe(td, newMeasurement(ob))
+}
+
+// observe processes a single measurement with the filter, td is the time since last update,
+// and records the step.
+func (s *Smoother) observe(td float64, m *measurement) error {
+	if !s.filter.Initialized() {
+		if _, err := s.filter.observe(td, m); err != nil {
+			return err
+		}
+		s.steps = append(s.steps, smootherStep{
+			predicted: s.filter.snapshot(),
+			filtered:  s.filter.snapshot(),
+		})
+		return nil
+	}
+	if err := s.filter.Predict(td); err != nil {
+		return err
+	}
+	step := smootherStep{
+		transition: s.filter.transition(td),
+		predicted:  s.filter.snapshot(),
+	}
+	if _, err := s.filter.update(m); err != nil {
+		return err
+	}
+	step.filtered = s.filter.snapshot()
+	s.steps = append(s.steps, step)
+	return nil
+}
+
+// Smooth returns the smoothed estimates, one for each recorded step.
+func (s *Smoother) Smooth() ([]*Estimated, error) {
+	smoothed, err := rtsSmooth(s.steps)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Estimated, len(smoothed))
+	for i := range smoothed {
+		res[i] = smoothed[i].estimate()
+	}
+	return res, nil
+}
+
+// rtsSmooth runs the Rauch-Tung-Striebel backward pass over the steps:
+//
+//	C(k) = P(k)*F(k+1)^T*P'(k+1)^-1
+//	x(k) = x(k) + C(k)*(xs(k+1) - x'(k+1))
+//	P(k) = P(k) + C(k)*(Ps(k+1) - P'(k+1))*C(k)^T
+//
+// where x(k), P(k) are filtered, x'(k), P'(k) are predicted and xs(k), Ps(k) are smoothed.
+func rtsSmooth(steps []smootherStep) ([]gaussian, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	smoothed := make([]gaussian, len(steps))
+	last := len(steps) - 1
+	smoothed[last] = steps[last].filtered
+	for k := last - 1; k >= 0; k-- {
+		next := &steps[k+1]
+		cur := &steps[k]
+
+		// C^T = P'(k+1)^-1*F(k+1)*P(k), solved with Cholesky, which is accurate even when
+		// the components have very different scales.
+		var chol mat.Cholesky
+		if ok := chol.Factorize(symmetric(next.predicted.cov)); !ok {
+			return nil, ErrInvalidCovariance
+		}
+		var fp mat.Dense
+		fp.Mul(next.transition, cur.filtered.cov)
+		var ct mat.Dense
+		if err := chol.SolveTo(&ct, &fp); err != nil {
+			if _, ok := err.(mat.Condition); !ok {
+				return nil, err
+			}
+		}
+		c := ct.T()
+
+		var dx mat.VecDense
+		dx.SubVec(smoothed[k+1].state, next.predicted.state)
+		x := mat.NewVecDense(dx.Len(), nil)
+		x.MulVec(c, &dx)
+		x.AddVec(x, cur.filtered.state)
+
+		var dp mat.Dense
+		dp.Sub(smoothed[k+1].cov, next.predicted.cov)
+		var p mat.Dense
+		p.Mul(c, &dp)
+		p.Mul(&p, c.T())
+		p.Add(&p, cur.filtered.cov)
+		symmetrize(&p)
+
+		smoothed[k] = gaussian{state: x, cov: &p}
+	}
+	return smoothed, nil
+}
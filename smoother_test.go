@@ -0,0 +1,269 @@
+package kalman
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/regnull/kalman/geo"
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSmoothLastIsFiltered(t *testing.T) {
+	// The last smoothed estimate is the filtered one, the earlier ones are more accurate.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SX: 0.5, SY: 0.5, SZ: 0.5, SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	s := NewSmoother(f)
+	for i := 0; i < 10; i++ {
+		assert.NoError(s.Observe(1.0, &Observed{
+			X:          float64(i),
+			XA:         1.0,
+			YA:         1.0,
+			ZA:         1.0,
+			Components: ComponentPosition,
+		}))
+	}
+	smoothed, err := s.Smooth()
+	assert.NoError(err)
+	assert.Len(smoothed, 10)
+	e := f.Estimate()
+	assert.InDelta(e.X, smoothed[9].X, 1e-12)
+	assert.True(mat.EqualApprox(e.Cov, smoothed[9].Cov, 1e-12))
+	for i := 0; i < 9; i++ {
+		assert.True(smoothed[i].XA <= smoothed[9].XA+1e-12)
+	}
+	// Middle of the track benefits from both sides.
+	assert.True(smoothed[5].XA < smoothed[9].XA)
+}
+
+func TestSmoothClosedForm(t *testing.T) {
+	// Two observations of a constant without process noise: both smoothed estimates are the average.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	s := NewSmoother(f)
+	ob := &Observed{
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.01,
+		VYA: 0.01,
+		VZA: 0.01,
+	}
+	assert.NoError(s.Observe(0.0, ob))
+	ob.X = 2.0
+	assert.NoError(s.Observe(0.0, ob))
+	smoothed, err := s.Smooth()
+	assert.NoError(err)
+	assert.InDelta(1.0, smoothed[0].X, 1e-9)
+	assert.InDelta(1.0, smoothed[1].X, 1e-9)
+	assert.InDelta(0.5, smoothed[0].Cov.At(_X, _X), 1e-9)
+	assert.InDelta(0.5, smoothed[1].Cov.At(_X, _X), 1e-9)
+}
+
+func TestSmoothReducesError(t *testing.T) {
+	// On a noisy track, the smoothed estimates are closer to the truth than the filtered ones.
+	assert := assert.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	s := NewSmoother(f)
+	var filteredErr, truth []float64
+	for i := 0; i < 100; i++ {
+		x := 2.0 * float64(i)
+		truth = append(truth, x)
+		assert.NoError(s.Observe(1.0, &Observed{
+			X:          x + 3.0*rnd.NormFloat64(),
+			XA:         3.0,
+			YA:         3.0,
+			ZA:         3.0,
+			Components: ComponentPosition,
+		}))
+		filteredErr = append(filteredErr, f.Estimate().X-x)
+	}
+	smoothed, err := s.Smooth()
+	assert.NoError(err)
+	var fe, se float64
+	for i := range truth {
+		fe += filteredErr[i] * filteredErr[i]
+		d := smoothed[i].X - truth[i]
+		se += d * d
+	}
+	assert.True(se < fe/2.0, "smoothed %f, filtered %f", se, fe)
+}
+
+func TestSmoothWithPredict(t *testing.T) {
+	// A gap without observations is filled in by the smoother.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	s := NewSmoother(f)
+	ob := &Observed{
+		XA:         1.0,
+		YA:         1.0,
+		ZA:         1.0,
+		Components: ComponentPosition,
+	}
+	for i := 0; i < 5; i++ {
+		ob.X = float64(i)
+		assert.NoError(s.Observe(1.0, ob))
+	}
+	assert.NoError(s.Predict(5.0))
+	for i := 10; i < 15; i++ {
+		ob.X = float64(i)
+		assert.NoError(s.Observe(1.0, ob))
+	}
+	smoothed, err := s.Smooth()
+	assert.NoError(err)
+	assert.Len(smoothed, 11)
+	assert.InDelta(9.0, smoothed[5].X, 0.5)
+}
+
+func TestGeoSmooth(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	})
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	var obs []TimedGeoObserved
+	var truth []float64
+	for i := 0; i < 60; i++ {
+		lat := 43.0 + 1.5*float64(i)/metersPerDegreeLat
+		truth = append(truth, lat)
+		obs = append(obs, TimedGeoObserved{
+			TD: 1.0,
+			GeoObserved: GeoObserved{
+				Lat:                lat + 10.0*rnd.NormFloat64()/metersPerDegreeLat,
+				Lng:                -71.0,
+				HorizontalAccuracy: 10.0,
+				Components:         GeoPosition,
+			},
+		})
+	}
+	smoothed, err := g.Smooth(obs)
+	assert.NoError(err)
+	assert.Len(smoothed, len(obs))
+	var oe, se float64
+	for i := range obs {
+		d := (obs[i].Lat - truth[i]) * metersPerDegreeLat
+		oe += d * d
+		d = (smoothed[i].Lat - truth[i]) * metersPerDegreeLat
+		se += d * d
+	}
+	assert.True(math.Sqrt(se/60.0) < math.Sqrt(oe/60.0)/2.0)
+
+	// The filter doesn't change, smoothing again gives the same estimates.
+	assert.Nil(g.Estimate())
+	again, err := g.Smooth(obs)
+	assert.NoError(err)
+	assert.Equal(smoothed, again)
+	for i := range obs {
+		_, err = g.Observe(obs[i].TD, &obs[i].GeoObserved)
+		assert.NoError(err)
+	}
+	assert.InDelta(g.Estimate().Lat, smoothed[59].Lat, 1e-12)
+}
+
+func TestGeoFixedLagSmoother(t *testing.T) {
+	// Each emitted estimate is the same as the one smoothed over the observations up to
+	// the lag after it, and the memory stays bounded by the lag.
+	assert := assert.New(t)
+	d := &GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	}
+	g, err := NewGeoFilter(d)
+	assert.NoError(err)
+	s, err := NewGeoFixedLagSmoother(g, FixedLag{Steps: 3})
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	metersPerDegreeLat := geo.FastMetersPerDegreeLat(43.0)
+	var obs []TimedGeoObserved
+	var emitted []*GeoEstimated
+	for i := 0; i < 20; i++ {
+		obs = append(obs, TimedGeoObserved{
+			TD: 1.0,
+			GeoObserved: GeoObserved{
+				Lat:                43.0 + (1.5*float64(i)+10.0*rnd.NormFloat64())/metersPerDegreeLat,
+				Lng:                -71.0,
+				HorizontalAccuracy: 10.0,
+				Components:         GeoPosition,
+			},
+		})
+		res, err := s.Observe(1.0, &obs[i].GeoObserved)
+		assert.NoError(err)
+		if i < 3 {
+			assert.Empty(res)
+		} else {
+			assert.Len(res, 1)
+		}
+		emitted = append(emitted, res...)
+		assert.True(len(s.smoother.steps) <= 4)
+	}
+	assert.Len(emitted, 17)
+	for k := range emitted {
+		ref, err := NewGeoFilter(d)
+		assert.NoError(err)
+		smoothed, err := ref.Smooth(obs[:k+4])
+		assert.NoError(err)
+		assert.InDelta(smoothed[k].Lat, emitted[k].Lat, 1e-12)
+		assert.InDelta(smoothed[k].HorizontalAccuracy, emitted[k].HorizontalAccuracy, 1e-9)
+	}
+
+	// Flush emits the rest, which are the same as the full smoothing.
+	rest, err := s.Flush()
+	assert.NoError(err)
+	assert.Len(rest, 3)
+	assert.Empty(s.smoother.steps)
+	ref, err := NewGeoFilter(d)
+	assert.NoError(err)
+	smoothed, err := ref.Smooth(obs)
+	assert.NoError(err)
+	for i := range rest {
+		assert.InDelta(smoothed[17+i].Lat, rest[i].Lat, 1e-12)
+	}
+	assert.Equal(g.Estimate(), s.Estimate())
+}
+
+func TestGeoFixedLagSmootherSeconds(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{
+		BaseLat:           43.0,
+		DistancePerSecond: 0.1,
+		SpeedPerSecond:    0.1,
+	})
+	assert.NoError(err)
+	s, err := NewGeoFixedLagSmoother(g, FixedLag{Seconds: 2.0})
+	assert.NoError(err)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		HorizontalAccuracy: 10.0,
+		Components:         GeoPosition,
+	}
+	var counts []int
+	for _, td := range []float64{0.0, 0.5, 0.5, 0.5, 0.5, 3.0} {
+		res, err := s.Observe(td, ob)
+		assert.NoError(err)
+		counts = append(counts, len(res))
+	}
+	assert.Equal([]int{0, 0, 0, 0, 1, 4}, counts)
+	assert.Len(s.smoother.steps, 1)
+}
+
+func TestGeoFixedLagSmootherInvalidLag(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1})
+	assert.NoError(err)
+	for _, lag := range []FixedLag{{}, {Steps: 1, Seconds: 1.0}, {Steps: -1}, {Seconds: -1.0}} {
+		_, err := NewGeoFixedLagSmoother(g, lag)
+		assert.Equal(ErrInvalidLag, err)
+	}
+}
@@ -0,0 +1,130 @@
+package kalman
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Square root filter, the covariance is kept as P = S*S^T and only S is propagated.
+// See Grewal, Andrews, "Kalman Filtering: Theory and Practice", chapter 6.
+
+// sqrtPredict sets x to F*x and s to the square root of F*S*S^T*F^T + Q.
+//
+// The stacked matrix [S^T*F^T; sqrt(Q)^T] is factorized as QR, then
+// F*S*S^T*F^T + Q = R^T*R and R^T is the new square root.
+func sqrtPredict(x *mat.VecDense, s *mat.Dense, f, q mat.Matrix) {
+	var fx mat.VecDense
+	fx.MulVec(f, x)
+	x.CopyVec(&fx)
+
+	n := x.Len()
+	var fs mat.Dense
+	fs.Mul(f, s)
+	a := mat.NewDense(2*n, n, nil)
+	a.Slice(0, n, 0, n).(*mat.Dense).Copy(fs.T())
+	a.Slice(n, 2*n, 0, n).(*mat.Dense).Copy(sqrtPSD(q).T())
+
+	var qr mat.QR
+	qr.Factorize(a)
+	r := mat.NewDense(n, n, nil)
+	qr.RTo(r)
+	// Make the diagonal positive, so that S is the Cholesky factor.
+	for i := 0; i < n; i++ {
+		if r.At(i, i) < 0 {
+			for j := i; j < n; j++ {
+				r.Set(i, j, -r.At(i, j))
+			}
+		}
+	}
+	s.Copy(r.T())
+}
+
+// sqrtCorrect updates x and s with the measurement z = H*x + v, v ~ N(0, R).
+//
+// The measurement is whitened with the Cholesky factor of R and then processed one
+// row at a time with Potter's rank-one update of S.
+func sqrtCorrect(x *mat.VecDense, s *mat.Dense, z mat.Vector, h, r mat.Matrix) error {
+	m, n := h.Dims()
+	var chol mat.Cholesky
+	if ok := chol.Factorize(symmetric(r)); !ok {
+		return ErrInvalidMeasurementNoise
+	}
+	var l mat.TriDense
+	chol.LTo(&l)
+	// Solve L*zw = z and L*hw = H. Substitution is accurate even when the components have
+	// very different scales, so the condition number is not checked.
+	var zw mat.VecDense
+	if err := zw.SolveVec(&l, z); err != nil {
+		if _, ok := err.(mat.Condition); !ok {
+			return err
+		}
+	}
+	var hw mat.Dense
+	if err := hw.Solve(&l, h); err != nil {
+		if _, ok := err.(mat.Condition); !ok {
+			return err
+		}
+	}
+
+	phi := mat.NewVecDense(n, nil)
+	k := mat.NewVecDense(n, nil)
+	var sphi mat.VecDense
+	for i := 0; i < m; i++ {
+		row := hw.RowView(i)
+		// phi = S^T*h^T, a = 1/(phi^T*phi + 1).
+		phi.MulVec(s.T(), row)
+		a := 1.0 / (mat.Dot(phi, phi) + 1.0)
+		gamma := a / (1.0 + math.Sqrt(a))
+		sphi.MulVec(s, phi)
+		k.ScaleVec(a, &sphi)
+		// x = x + K*(z - h*x).
+		x.AddScaledVec(x, zw.AtVec(i)-mat.Dot(row, x), k)
+		// S = S - gamma*S*phi*phi^T.
+		for p := 0; p < n; p++ {
+			for q := 0; q < n; q++ {
+				s.Set(p, q, s.At(p, q)-gamma*sphi.AtVec(p)*phi.AtVec(q))
+			}
+		}
+	}
+	return nil
+}
+
+// sqrtPSD returns a square root of the positive semi-definite matrix m, m = S*S^T.
+// Negative eigenvalues caused by rounding errors are treated as zeros.
+func sqrtPSD(m mat.Matrix) *mat.Dense {
+	n, _ := m.Dims()
+	var chol mat.Cholesky
+	if chol.Factorize(symmetric(m)) {
+		var l mat.TriDense
+		chol.LTo(&l)
+		return mat.DenseCopyOf(&l)
+	}
+	// Not positive definite, for example zero process noise.
+	var eig mat.EigenSym
+	if !eig.Factorize(symmetric(m), true) {
+		return mat.NewDense(n, n, nil)
+	}
+	values := eig.Values(nil)
+	var v mat.Dense
+	eig.VectorsTo(&v)
+	for j := 0; j < n; j++ {
+		d := math.Sqrt(math.Max(values[j], 0.0))
+		for i := 0; i < n; i++ {
+			v.Set(i, j, v.At(i, j)*d)
+		}
+	}
+	return &v
+}
+
+// symmetric returns the symmetric part of m.
+func symmetric(m mat.Matrix) *mat.SymDense {
+	n, _ := m.Dims()
+	s := mat.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			s.SetSym(i, j, (m.At(i, j)+m.At(j, i))/2.0)
+		}
+	}
+	return s
+}
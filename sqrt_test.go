@@ -0,0 +1,163 @@
+package kalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSquareRootMatchesFilter(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  0.5,
+		SZ:  0.1,
+		SVX: 0.1,
+		SVY: 0.2,
+		SVZ: 0.3,
+		ST:  1.0}
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	s, err := NewFilter(d, WithSquareRoot())
+	assert.NoError(err)
+	for i := 0; i < 50; i++ {
+		ob := &Observed{
+			X:   float64(i) + math.Sin(float64(i)),
+			Y:   2.0 * float64(i),
+			Z:   math.Cos(float64(i)),
+			VX:  1.0,
+			VY:  2.0,
+			XA:  1.0,
+			YA:  2.0,
+			ZA:  3.0,
+			VXA: 0.1,
+			VYA: 0.2,
+			VZA: 0.3,
+		}
+		if i%4 == 1 {
+			ob.Components = ComponentPosition
+		}
+		if i%4 == 2 {
+			ob.Components = ComponentVelocity
+		}
+		_, err = f.Observe(1.5, ob)
+		assert.NoError(err)
+		_, err = s.Observe(1.5, ob)
+		assert.NoError(err)
+	}
+	assert.True(mat.EqualApprox(f.state, s.state, 1e-9))
+	assert.True(mat.EqualApprox(f.cov, s.cov, 1e-9))
+
+	// The factor is the square root of the covariance.
+	var ss mat.Dense
+	ss.Mul(s.sqrtCov, s.sqrtCov.T())
+	assert.True(mat.EqualApprox(&ss, s.cov, 1e-12))
+}
+
+func TestSquareRootNoProcessNoise(t *testing.T) {
+	// Zero process noise is positive semi-definite only.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{})
+	assert.NoError(err)
+	s, err := NewFilter(&ProcessNoise{}, WithSquareRoot())
+	assert.NoError(err)
+	ob := &Observed{
+		X:   10.0,
+		Y:   10.0,
+		Z:   10.0,
+		VX:  1.0,
+		XA:  1.0,
+		YA:  1.0,
+		ZA:  1.0,
+		VXA: 0.01,
+		VYA: 0.01,
+		VZA: 0.01,
+	}
+	for i := 0; i < 3; i++ {
+		_, err = f.Observe(1.0, ob)
+		assert.NoError(err)
+		_, err = s.Observe(1.0, ob)
+		assert.NoError(err)
+	}
+	assert.True(mat.EqualApprox(f.state, s.state, 1e-9))
+	assert.True(mat.EqualApprox(f.cov, s.cov, 1e-9))
+}
+
+func TestSquareRootZeroAccuracy(t *testing.T) {
+	assert := assert.New(t)
+	s, err := NewFilter(&ProcessNoise{}, WithSquareRoot())
+	assert.NoError(err)
+	ob := &Observed{XA: 1.0, YA: 1.0, ZA: 1.0, VXA: 1.0, VYA: 1.0, VZA: 1.0}
+	_, err = s.Observe(0.0, ob)
+	assert.NoError(err)
+	ob.XA = 0.0
+	_, err = s.Observe(1.0, ob)
+	assert.Equal(ErrInvalidMeasurementNoise, err)
+}
+
+func TestSquareRootLongRun(t *testing.T) {
+	assert := assert.New(t)
+	m, err := NewConstantAcceleration(&ProcessNoise{
+		SX:  1e-6,
+		SY:  1e-6,
+		SZ:  1e-6,
+		SVX: 1e-6,
+		SVY: 1e-6,
+		SVZ: 1e-6,
+		SAX: 1e-6,
+		SAY: 1e-6,
+		SAZ: 1e-6,
+		ST:  1.0})
+	assert.NoError(err)
+	s, err := NewFilterWithModel(m, WithSquareRoot())
+	assert.NoError(err)
+	for i := 0; i < 20000; i++ {
+		ob := &Observed{
+			X:          float64(i % 7),
+			Y:          float64(i % 11),
+			Z:          float64(i % 13),
+			XA:         1e-6,
+			YA:         1e-6,
+			ZA:         1e-6,
+			Components: ComponentPosition,
+		}
+		if _, err := s.Observe(0.1, ob); err != nil {
+			assert.NoError(err)
+			break
+		}
+	}
+	e := s.Estimate()
+	for _, a := range []float64{e.XA, e.YA, e.ZA, e.VXA, e.VYA, e.VZA, e.AXA, e.AYA, e.AZA} {
+		assert.False(math.IsNaN(a))
+		assert.True(a > 0.0)
+	}
+}
+
+func TestSquareRootLinear(t *testing.T) {
+	assert := assert.New(t)
+	m := &LinearModel{
+		F: mat.NewDense(2, 2, []float64{1.0, 1.0, 0.0, 1.0}),
+		B: mat.NewDense(2, 1, []float64{0.5, 1.0}),
+		Q: mat.NewDense(2, 2, []float64{0.25, 0.5, 0.5, 1.0}),
+		H: mat.NewDense(1, 2, []float64{1.0, 0.0}),
+		R: mat.NewDense(1, 1, []float64{2.0}),
+	}
+	x := mat.NewVecDense(2, []float64{0.0, 1.0})
+	p := mat.NewDense(2, 2, []float64{10.0, 0.0, 0.0, 10.0})
+	l, err := NewLinearFilter(m, x, p)
+	assert.NoError(err)
+	s, err := NewLinearFilter(m, x, p, WithSquareRoot())
+	assert.NoError(err)
+	for i := 0; i < 20; i++ {
+		u := mat.NewVecDense(1, []float64{0.1})
+		z := mat.NewVecDense(1, []float64{float64(i * i)})
+		assert.NoError(l.Predict(u))
+		assert.NoError(s.Predict(u))
+		assert.NoError(l.Update(z))
+		assert.NoError(s.Update(z))
+	}
+	assert.True(mat.EqualApprox(l.State(), s.State(), 1e-9))
+	assert.True(mat.EqualApprox(l.Covariance(), s.Covariance(), 1e-9))
+}
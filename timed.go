@@ -0,0 +1,103 @@
+package kalman
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// NonMonotonicError is returned when an observation or an estimate is requested for the time
+// before the last update.
+type NonMonotonicError struct {
+	Last time.Time // Time of the last update.
+	Time time.Time // Requested time.
+}
+
+// Error returns the error description.
+func (e *NonMonotonicError) Error() string {
+	return fmt.Sprintf("time %s is before the last update at %s", e.Time.Format(time.RFC3339Nano), e.Last.Format(time.RFC3339Nano))
+}
+
+// seconds returns the duration of td seconds, rounded to the nearest nanosecond, so that the
+// time advanced by repeated deltas doesn't drift from their sum.
+func seconds(td float64) time.Duration {
+	return time.Duration(math.Round(td * float64(time.Second)))
+}
+
+// ObserveAt processes the observation made at time t, which must not be before the last update.
+// If the time of the last update is unknown, such as after Init or Observe, the observation is
+// taken to be made at that time. An observation made at the same time as the last update, such
+// as another sensor sampled together with the previous one, is accepted and applied without
+// prediction.
+func (f *Filter) ObserveAt(t time.Time, ob *Observed) (Result, error) {
+	return f.observeAt(t, f.work.measurement(ob))
+}
+
+// observeAt processes the measurement made at time t.
+func (f *Filter) observeAt(t time.Time, m *measurement) (Result, error) {
+	td, err := f.since(t)
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := f.observe(td, m)
+	if err != nil {
+		return Result{}, err
+	}
+	f.last = t
+	return res, nil
+}
+
+// EstimateAt returns the state estimate predicted to time t, which must not be before the last
+// update, without changing the filter.
+func (f *Filter) EstimateAt(t time.Time) (*Estimated, error) {
+	if f.state == nil {
+		return nil, ErrNotInitialized
+	}
+	td, err := f.since(t)
+	if err != nil {
+		return nil, err
+	}
+	g := gaussian{state: f.predictState(td), cov: f.predictCov(td)}
+	return f.scaled(g.estimate()), nil
+}
+
+// LastUpdate returns the time of the last update, or zero time if it is unknown.
+func (f *Filter) LastUpdate() time.Time {
+	return f.last
+}
+
+// since returns the time from the last update to t in seconds, zero if the last update time is unknown.
+func (f *Filter) since(t time.Time) (float64, error) {
+	if f.last.IsZero() {
+		return 0.0, nil
+	}
+	if t.Before(f.last) {
+		return 0.0, &NonMonotonicError{Last: f.last, Time: t}
+	}
+	return t.Sub(f.last).Seconds(), nil
+}
+
+// ObserveAt processes the observation made at time t, which must not be before the last update,
+// see Filter.ObserveAt.
+func (g *GeoFilter) ObserveAt(t time.Time, ob *GeoObserved) (Result, error) {
+	return g.filter.observeAt(t, g.measurement(ob))
+}
+
+// EstimateAt returns the location estimate predicted to time t, which must not be before the last
+// update, without changing the filter.
+func (g *GeoFilter) EstimateAt(t time.Time) (*GeoEstimated, error) {
+	f := g.filter
+	if f.state == nil {
+		return nil, ErrNotInitialized
+	}
+	td, err := f.since(t)
+	if err != nil {
+		return nil, err
+	}
+	return g.scaled(geoEstimate(f.predictState(td), f.predictCov(td))), nil
+}
+
+// LastUpdate returns the time of the last update, or zero time if it is unknown.
+func (g *GeoFilter) LastUpdate() time.Time {
+	return g.filter.last
+}
@@ -0,0 +1,149 @@
+package kalman
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveAt(t *testing.T) {
+	assert := assert.New(t)
+	d := &ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0}
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	g, err := NewFilter(d)
+	assert.NoError(err)
+	assert.True(f.LastUpdate().IsZero())
+	_, err = f.EstimateAt(time.Now())
+	assert.Equal(ErrNotInitialized, err)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	now := start
+	for i := 0; i < 10; i++ {
+		td := 0.25 * float64(i%3)
+		now = now.Add(seconds(td))
+		ob := randomObserved(rnd, i)
+		_, err = f.ObserveAt(now, ob)
+		assert.NoError(err)
+		_, err = g.Observe(td, ob)
+		assert.NoError(err)
+		assert.Equal(now, f.LastUpdate())
+	}
+	assert.Equal(g.Estimate(), f.Estimate())
+
+	// The estimate in the future is the prediction, the filter doesn't change.
+	e, err := f.EstimateAt(now.Add(2 * time.Second))
+	assert.NoError(err)
+	assert.NoError(g.Predict(2.0))
+	assert.Equal(g.Estimate(), e)
+	assert.Equal(now, f.LastUpdate())
+	e, err = f.EstimateAt(now)
+	assert.NoError(err)
+	assert.Equal(f.Estimate(), e)
+
+	// Observe and Predict advance the time of the last update.
+	assert.NoError(f.Predict(1.5))
+	assert.Equal(now.Add(1500*time.Millisecond), f.LastUpdate())
+}
+
+func TestObserveAtNonMonotonic(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	_, err = f.ObserveAt(start, randomObserved(rnd, 0))
+	assert.NoError(err)
+	e := f.Estimate()
+
+	_, err = f.ObserveAt(start.Add(-time.Second), randomObserved(rnd, 1))
+	var nm *NonMonotonicError
+	assert.True(errors.As(err, &nm))
+	assert.Equal(start, nm.Last)
+	assert.Equal(start.Add(-time.Second), nm.Time)
+	assert.Contains(err.Error(), "before the last update")
+	_, err = f.EstimateAt(start.Add(-time.Second))
+	assert.True(errors.As(err, &nm))
+	assert.Equal(e, f.Estimate())
+	assert.Equal(start, f.LastUpdate())
+
+	// Observations made at the same time are applied without prediction.
+	g, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	assert.NoError(g.Init(f.state, f.cov))
+	ob := randomObserved(rnd, 1)
+	res, err := f.ObserveAt(start, ob)
+	assert.NoError(err)
+	assert.Equal(Accepted, res.Status)
+	_, err = g.Observe(0.0, ob)
+	assert.NoError(err)
+	assert.Equal(g.Estimate(), f.Estimate())
+	assert.Equal(start, f.LastUpdate())
+}
+
+func TestLastUpdateNoDrift(t *testing.T) {
+	// The time advanced by relative deltas is their sum, 1.001 s times 1e9 is just below a whole
+	// number of nanoseconds in floating point.
+	assert := assert.New(t)
+	f, err := NewFilter(&ProcessNoise{SVX: 0.1, SVY: 0.1, SVZ: 0.1, ST: 1.0})
+	assert.NoError(err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	_, err = f.ObserveAt(start, randomObserved(rnd, 0))
+	assert.NoError(err)
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			assert.NoError(f.Predict(1.001))
+		} else {
+			_, err = f.Observe(1.001, randomObserved(rnd, i))
+			assert.NoError(err)
+		}
+	}
+	assert.Equal(start.Add(1001*time.Second), f.LastUpdate())
+}
+
+func TestGeoObserveAt(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGeoFilter(&GeoProcessNoise{BaseLat: 43.0, DistancePerSecond: 0.1, SpeedPerSecond: 0.1})
+	assert.NoError(err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ob := &GeoObserved{
+		Lat:                43.0,
+		Lng:                -71.0,
+		Speed:              10.0,
+		SpeedAccuracy:      0.1,
+		DirectionAccuracy:  1.0,
+		HorizontalAccuracy: 10.0,
+		Components:         GeoPosition | GeoVelocity,
+	}
+
+	// With a prior, the time of the first observation is taken as the time of the prior.
+	assert.NoError(g.Init(&GeoEstimated{Lat: 43.0, Lng: -71.0, HorizontalAccuracy: 100.0}))
+	_, err = g.ObserveAt(start, ob)
+	assert.NoError(err)
+	assert.Equal(start, g.LastUpdate())
+	e, err := g.EstimateAt(start.Add(10 * time.Second))
+	assert.NoError(err)
+	assert.InDelta(100.0, (e.Lat-43.0)*111000.0, 5.0)
+	_, err = g.ObserveAt(start.Add(-time.Millisecond), ob)
+	assert.IsType(&NonMonotonicError{}, err)
+
+	// The time of the last update is saved.
+	data, err := g.MarshalBinary()
+	assert.NoError(err)
+	var h GeoFilter
+	assert.NoError(h.UnmarshalBinary(data))
+	assert.Equal(start, h.LastUpdate())
+	data, err = json.Marshal(g)
+	assert.NoError(err)
+	var j GeoFilter
+	assert.NoError(json.Unmarshal(data, &j))
+	assert.True(start.Equal(j.LastUpdate()))
+	_, err = j.ObserveAt(start.Add(-time.Second), ob)
+	assert.IsType(&NonMonotonicError{}, err)
+}
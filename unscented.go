@@ -0,0 +1,196 @@
+package kalman
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrInvalidUnscentedParams is returned when the unscented transform parameters can't be used.
+var ErrInvalidUnscentedParams = fmt.Errorf("invalid unscented transform parameters")
+
+// UnscentedParams are the parameters of the scaled unscented transform.
+type UnscentedParams struct {
+	Alpha float64 // Spread of the sigma points around the mean, usually small, 0 < Alpha <= 1.
+	Beta  float64 // Prior knowledge of the distribution, 2 is optimal for Gaussian.
+	Kappa float64 // Secondary scaling, usually 0 or 3 - n.
+}
+
+// DefaultUnscentedParams are the commonly used unscented transform parameters.
+var DefaultUnscentedParams = UnscentedParams{Alpha: 1e-3, Beta: 2.0, Kappa: 0.0}
+
+// UnscentedFilter is an unscented Kalman filter. Instead of linearizing, it propagates a set
+// of sigma points through the nonlinear functions, which captures the mean and covariance
+// to the second order. Jacobians in the model and measurements are not used.
+type UnscentedFilter struct {
+	gaussian
+	model  *NonlinearModel
+	lambda float64   // Sigma points scaling, Alpha^2*(n + Kappa) - n.
+	wm     []float64 // Mean weights.
+	wc     []float64 // Covariance weights.
+}
+
+// NewUnscentedFilter creates and returns a new unscented Kalman filter with the initial state x and
+// covariance p. If params is nil, DefaultUnscentedParams are used.
+func NewUnscentedFilter(m *NonlinearModel, x mat.Vector, p mat.Matrix, params *UnscentedParams) (*UnscentedFilter, error) {
+	if m == nil || m.Transition == nil || m.ProcessNoise == nil {
+		return nil, ErrInvalidModel
+	}
+	if x == nil || !isSquare(p, x.Len()) {
+		return nil, ErrDimensions
+	}
+	n := x.Len()
+	if params == nil {
+		params = &DefaultUnscentedParams
+	}
+	lambda := params.Alpha*params.Alpha*(float64(n)+params.Kappa) - float64(n)
+	if params.Alpha <= 0 || float64(n)+lambda <= 0 {
+		return nil, ErrInvalidUnscentedParams
+	}
+	wm := make([]float64, 2*n+1)
+	wc := make([]float64, 2*n+1)
+	wm[0] = lambda / (float64(n) + lambda)
+	wc[0] = wm[0] + 1.0 - params.Alpha*params.Alpha + params.Beta
+	for i := 1; i < 2*n+1; i++ {
+		wm[i] = 1.0 / (2.0 * (float64(n) + lambda))
+		wc[i] = wm[i]
+	}
+	u := &UnscentedFilter{
+		model:  m,
+		lambda: lambda,
+		wm:     wm,
+		wc:     wc,
+	}
+	u.reset(mat.VecDenseCopyOf(x), mat.DenseCopyOf(p))
+	return u, nil
+}
+
+// sigmaPoints returns the sigma points of the current state, as columns.
+func (u *UnscentedFilter) sigmaPoints() *mat.Dense {
+	n := u.state.Len()
+	var scaled mat.Dense
+	scaled.Scale(float64(n)+u.lambda, u.cov)
+	s := sqrtPSD(&scaled)
+	points := mat.NewDense(n, 2*n+1, nil)
+	for i := 0; i < n; i++ {
+		points.Set(i, 0, u.state.AtVec(i))
+		for j := 0; j < n; j++ {
+			points.Set(i, j+1, u.state.AtVec(i)+s.At(i, j))
+			points.Set(i, j+n+1, u.state.AtVec(i)-s.At(i, j))
+		}
+	}
+	return points
+}
+
+// Predict advances the state by td without a measurement.
+func (u *UnscentedFilter) Predict(td float64) error {
+	n := u.state.Len()
+	points := u.sigmaPoints()
+	q := u.model.ProcessNoise(td)
+	if !isSquare(q, n) {
+		return ErrDimensions
+	}
+
+	moved := mat.NewDense(n, 2*n+1, nil)
+	for k := 0; k < 2*n+1; k++ {
+		y := u.model.Transition(points.ColView(k), td)
+		if y == nil || y.Len() != n {
+			return ErrDimensions
+		}
+		moved.SetCol(k, y.RawVector().Data)
+	}
+	x := mat.NewVecDense(n, nil)
+	for k := 0; k < 2*n+1; k++ {
+		x.AddScaledVec(x, u.wm[k], moved.ColView(k))
+	}
+	p := mat.DenseCopyOf(q)
+	var d mat.VecDense
+	for k := 0; k < 2*n+1; k++ {
+		d.SubVec(moved.ColView(k), x)
+		p.RankOne(p, u.wc[k], &d, &d)
+	}
+	symmetrize(p)
+	u.reset(x, p)
+	return nil
+}
+
+// Update corrects the state with the measurement.
+func (u *UnscentedFilter) Update(ob *NonlinearMeasurement) error {
+	if ob == nil || ob.Z == nil || ob.H == nil {
+		return ErrInvalidMeasurement
+	}
+	n := u.state.Len()
+	m := ob.Z.Len()
+	if !isSquare(ob.R, m) {
+		return ErrDimensions
+	}
+	points := u.sigmaPoints()
+	measured := make([]*mat.VecDense, 2*n+1)
+	for k := range measured {
+		measured[k] = ob.H(points.ColView(k))
+		if measured[k] == nil || measured[k].Len() != m {
+			return ErrDimensions
+		}
+	}
+
+	// The mean is accumulated as residuals from the central point, so that the
+	// residual function can handle the angles.
+	dz := make([]*mat.VecDense, 2*n+1)
+	zMean := mat.VecDenseCopyOf(measured[0])
+	for k := 1; k < 2*n+1; k++ {
+		zMean.AddScaledVec(zMean, u.wm[k], subtract(ob.Residual, measured[k], measured[0]))
+	}
+	for k := range dz {
+		dz[k] = subtract(ob.Residual, measured[k], zMean)
+	}
+
+	s := mat.DenseCopyOf(ob.R)
+	pxz := mat.NewDense(n, m, nil)
+	var dx mat.VecDense
+	for k := range dz {
+		s.RankOne(s, u.wc[k], dz[k], dz[k])
+		dx.SubVec(points.ColView(k), u.state)
+		pxz.RankOne(pxz, u.wc[k], &dx, dz[k])
+	}
+
+	var si mat.Dense
+	if err := si.Inverse(s); err != nil {
+		return err
+	}
+	var k mat.Dense
+	k.Mul(pxz, &si)
+	var ky mat.VecDense
+	ky.MulVec(&k, residual(ob, zMean))
+	u.state.AddVec(u.state, &ky)
+
+	var ks mat.Dense
+	ks.Mul(&k, s)
+	var ksk mat.Dense
+	ksk.Mul(&ks, k.T())
+	u.cov.Sub(u.cov, &ksk)
+	symmetrize(u.cov)
+	return nil
+}
+
+// Observe advances the state by td and corrects it with the measurement.
+func (u *UnscentedFilter) Observe(td float64, ob *NonlinearMeasurement) error {
+	if err := u.Predict(td); err != nil {
+		return err
+	}
+	return u.Update(ob)
+}
+
+// State returns a copy of the current state.
+func (u *UnscentedFilter) State() *mat.VecDense {
+	return mat.VecDenseCopyOf(u.state)
+}
+
+// Covariance returns a copy of the current state covariance.
+func (u *UnscentedFilter) Covariance() *mat.Dense {
+	return mat.DenseCopyOf(u.cov)
+}
+
+// Estimate returns the current state estimate, assuming the motion model state layout.
+func (u *UnscentedFilter) Estimate() *Estimated {
+	return u.estimate()
+}
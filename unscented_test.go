@@ -0,0 +1,178 @@
+package kalman
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestUnscentedMatchesFilter(t *testing.T) {
+	// On a linear problem, the unscented transform is exact.
+	assert := assert.New(t)
+	d := &ProcessNoise{
+		SX:  1.0,
+		SY:  1.0,
+		SZ:  1.0,
+		SVX: 0.1,
+		SVY: 0.1,
+		SVZ: 0.1,
+		ST:  1.0}
+	cv, err := NewConstantVelocity(d)
+	assert.NoError(err)
+	f, err := NewFilter(d)
+	assert.NoError(err)
+	ob := &Observed{
+		X:   1.0,
+		Y:   2.0,
+		Z:   3.0,
+		XA:  1.0,
+		YA:  2.0,
+		ZA:  3.0,
+		VXA: 10.0,
+		VYA: 10.0,
+		VZA: 10.0,
+	}
+	_, err = f.Observe(0.0, ob)
+	assert.NoError(err)
+
+	for _, params := range []*UnscentedParams{nil, {Alpha: 1.0, Beta: 2.0, Kappa: -3.0}, {Alpha: 0.5, Beta: 0.0, Kappa: 1.0}} {
+		f1, err := NewFilter(d)
+		assert.NoError(err)
+		f1.reset(mat.VecDenseCopyOf(f.state), mat.DenseCopyOf(f.cov))
+		u, err := NewUnscentedFilter(NewNonlinearModel(cv), f.state, f.cov, params)
+		assert.NoError(err)
+		for i := 0; i < 10; i++ {
+			ob.X = float64(i)
+			ob.Y = 2.0 * float64(i)
+			ob.Z = -float64(i)
+			ob.Components = ComponentPosition
+			_, err = f1.Observe(1.0, ob)
+			assert.NoError(err)
+			assert.NoError(u.Observe(1.0, &NonlinearMeasurement{
+				Z: mat.NewVecDense(3, []float64{ob.X, ob.Y, ob.Z}),
+				H: func(x mat.Vector) *mat.VecDense {
+					return mat.NewVecDense(3, []float64{x.AtVec(_X), x.AtVec(_Y), x.AtVec(_Z)})
+				},
+				R: mat.NewDiagDense(3, []float64{1.0, 4.0, 9.0}),
+			}))
+		}
+		assert.True(mat.EqualApprox(f1.state, u.State(), 1e-6))
+		assert.True(mat.EqualApprox(f1.cov, u.Covariance(), 1e-6))
+	}
+}
+
+func TestUnscentedPolarConsistency(t *testing.T) {
+	// Velocity observed as speed and heading with a large heading uncertainty at low speed.
+	// The estimate is consistent if the average normalized estimation error squared (NEES)
+	// is close to the state size. With the default parameters, the unscented filter must be
+	// closer than the linear filter with the speed and heading converted to the velocity, as
+	// GeoFilter does, and than the extended one.
+	assert := assert.New(t)
+	cv, err := NewConstantVelocity(&ProcessNoise{})
+	assert.NoError(err)
+	m := NewNonlinearModel(cv)
+	rnd := rand.New(rand.NewSource(1))
+
+	prior := mat.NewVecDense(_N, []float64{0.0, 0.0, 0.0, 1.0, 1.0, 0.0})
+	priorSigma := []float64{1.0, 1.0, 1.0, 1.0, 1.0, 1.0}
+	priorCov := mat.NewDiagDense(_N, []float64{1.0, 1.0, 1.0, 1.0, 1.0, 1.0})
+	speedAccuracy := 0.1
+	headingAccuracy := 0.5
+
+	trials := 2000
+	var neesLinear, neesEKF, neesUKF float64
+	for i := 0; i < trials; i++ {
+		truth := mat.NewVecDense(_N, nil)
+		for j := 0; j < _N; j++ {
+			truth.SetVec(j, prior.AtVec(j)+priorSigma[j]*rnd.NormFloat64())
+		}
+		vx := truth.AtVec(_VX)
+		vy := truth.AtVec(_VY)
+		speed := math.Hypot(vx, vy) + speedAccuracy*rnd.NormFloat64()
+		heading := math.Atan2(vy, vx) + headingAccuracy*rnd.NormFloat64()
+		ob := NewSpeedHeadingMeasurement(speed, heading, speedAccuracy, headingAccuracy)
+
+		f, err := NewFilter(&ProcessNoise{}, WithInitialState(prior, priorCov))
+		assert.NoError(err)
+		vxa := speedLatAccuracy(speed, speedAccuracy, heading, headingAccuracy, 1.0)
+		vya := speedLngAccuracy(speed, speedAccuracy, heading, headingAccuracy, 1.0)
+		c := speedLatLngCovariance(speed, speedAccuracy, heading, headingAccuracy, 1.0, 1.0)
+		_, err = f.ObserveCorrelated(0.0, &CorrelatedObserved{
+			VX:         speed * math.Cos(heading),
+			VY:         speed * math.Sin(heading),
+			Cov:        mat.NewDense(2, 2, []float64{vxa * vxa, c, c, vya * vya}),
+			Components: ComponentVX | ComponentVY,
+		})
+		assert.NoError(err)
+		neesLinear += nees(f.state, f.cov, truth)
+
+		e, err := NewExtendedFilter(m, prior, priorCov)
+		assert.NoError(err)
+		assert.NoError(e.Update(ob))
+		neesEKF += nees(e.state, e.cov, truth)
+
+		u, err := NewUnscentedFilter(m, prior, priorCov, nil)
+		assert.NoError(err)
+		assert.NoError(u.Update(ob))
+		neesUKF += nees(u.state, u.cov, truth)
+	}
+	neesLinear /= float64(trials)
+	neesEKF /= float64(trials)
+	neesUKF /= float64(trials)
+	t.Logf("average NEES, linear: %f, extended: %f, unscented: %f", neesLinear, neesEKF, neesUKF)
+	assert.True(math.Abs(neesUKF-_N) < math.Abs(neesLinear-_N))
+	assert.True(math.Abs(neesUKF-_N) < math.Abs(neesEKF-_N))
+}
+
+func TestUnscentedInvalid(t *testing.T) {
+	assert := assert.New(t)
+	cv, err := NewConstantVelocity(&ProcessNoise{})
+	assert.NoError(err)
+	_, err = NewUnscentedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(6), &UnscentedParams{})
+	assert.Equal(ErrInvalidUnscentedParams, err)
+	_, err = NewUnscentedFilter(nil, mat.NewVecDense(6, nil), eye(6), nil)
+	assert.Equal(ErrInvalidModel, err)
+	_, err = NewUnscentedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(3), nil)
+	assert.Equal(ErrDimensions, err)
+	_, err = NewUnscentedFilter(NewNonlinearModel(cv), nil, eye(6), nil)
+	assert.Equal(ErrDimensions, err)
+	_, err = NewUnscentedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), nil, nil)
+	assert.Equal(ErrDimensions, err)
+
+	// The functions returning the wrong number of values or nil.
+	for _, f := range []func(x mat.Vector) *mat.VecDense{
+		func(x mat.Vector) *mat.VecDense { return mat.NewVecDense(1, nil) },
+		func(x mat.Vector) *mat.VecDense { return nil },
+	} {
+		m := &NonlinearModel{
+			Transition:   func(x mat.Vector, td float64) *mat.VecDense { return f(x) },
+			ProcessNoise: func(td float64) mat.Matrix { return mat.NewDense(6, 6, nil) },
+		}
+		u, err := NewUnscentedFilter(m, mat.NewVecDense(6, nil), eye(6), nil)
+		assert.NoError(err)
+		assert.Equal(ErrDimensions, u.Predict(1.0))
+		u, err = NewUnscentedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(6), nil)
+		assert.NoError(err)
+		assert.Equal(ErrDimensions, u.Update(&NonlinearMeasurement{Z: mat.NewVecDense(2, nil), H: f, R: eye(2)}))
+	}
+	u, err := NewUnscentedFilter(NewNonlinearModel(cv), mat.NewVecDense(6, nil), eye(6), nil)
+	assert.NoError(err)
+	assert.Equal(ErrInvalidMeasurement, u.Update(&NonlinearMeasurement{Z: mat.NewVecDense(2, nil), R: eye(2)}))
+	assert.Equal(ErrInvalidMeasurement, u.Update(&NonlinearMeasurement{H: func(x mat.Vector) *mat.VecDense { return nil }}))
+	assert.Equal(ErrInvalidMeasurement, u.Update(nil))
+	assert.Equal(mat.NewVecDense(6, nil), u.State())
+}
+
+// nees returns the normalized estimation error squared, (x - truth)^T*P^-1*(x - truth).
+func nees(x mat.Vector, p mat.Matrix, truth mat.Vector) float64 {
+	var d mat.VecDense
+	d.SubVec(x, truth)
+	var pi mat.Dense
+	if err := pi.Inverse(p); err != nil {
+		return math.Inf(1)
+	}
+	return mat.Inner(&d, &pi, &d)
+}
@@ -0,0 +1,227 @@
+package kalman
+
+import (
+	"math"
+	"math/bits"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// arithmetic does the computations of the filter steps, using the transition and the process
+// noise in the workspace.
+type arithmetic interface {
+	// predict sets x to F*x and p to F*P*F^T + Q.
+	predict(x *mat.VecDense, p *mat.Dense)
+	// factorize computes the innovation of the measurement and the Cholesky factor of its covariance,
+	// with r scaled by scale. It returns false if the covariance is not positive definite.
+	factorize(x *mat.VecDense, p *mat.Dense, m *measurement, r *mat.Dense, scale float64) bool
+	// whitened returns the whitened innovation of the factorized measurement with m components.
+	whitened(m int) []float64
+	// correct updates x and p with the measurement factorized for them, r is scaled by scale.
+	correct(x *mat.VecDense, p *mat.Dense, m *measurement, r *mat.Dense, scale float64, form UpdateForm)
+}
+
+// workspace holds the preallocated storage of Filter, reused between the steps so that predicting
+// and updating don't allocate. The update uses the structure of the measurement matrix, which
+// selects the observed components of the state: H*P is a subset of rows of P, and H*P*H^T is
+// a subset of its rows and columns.
+type workspace struct {
+	n   int
+	tr  *mat.Dense    // Transition, n by n.
+	trT mat.Matrix    // Transposed transition.
+	q   *mat.Dense    // Process noise covariance, n by n.
+	fp  *mat.Dense    // F*P, n by n.
+	fx  *mat.VecDense // F*x.
+
+	meas measurement           // Measurement being processed.
+	idx  [_N]int               // Storage of the observed state indices.
+	z    [_N + 1]*mat.VecDense // Observed values, by the number of observed components.
+	r    [_N + 1]*mat.Dense    // Measurement noise covariance, by the number of observed components.
+	y    [_N]float64           // Innovation.
+	e    [_N]float64           // Whitened innovation, L^-1*y.
+	l    [_N * _N]float64      // Cholesky factor of the innovation covariance, lower, row stride _N.
+	k    []float64             // Kalman gain, n by m, row stride _N.
+	kr   []float64             // K*R, n by m, row stride _N.
+	ap   []float64             // (I-K*H)*P, n by n.
+}
+
+// newWorkspace returns the workspace for the state of size n.
+func newWorkspace(n int) *workspace {
+	w := &workspace{
+		n:  n,
+		tr: mat.NewDense(n, n, nil),
+		q:  mat.NewDense(n, n, nil),
+		fp: mat.NewDense(n, n, nil),
+		fx: mat.NewVecDense(n, nil),
+		k:  make([]float64, n*_N),
+		kr: make([]float64, n*_N),
+		ap: make([]float64, n*n),
+	}
+	w.trT = w.tr.T()
+	for k := 1; k <= _N; k++ {
+		w.z[k] = mat.NewVecDense(k, nil)
+		w.r[k] = mat.NewDense(k, k, nil)
+	}
+	return w
+}
+
+// measurement returns the workspace measurement set to ob. It is only valid until the next call.
+func (w *workspace) measurement(ob *Observed) *measurement {
+	k := bits.OnesCount8(uint8(ob.components()))
+	w.meas = measurement{idx: w.idx[:0], z: w.z[k], r: w.r[k]}
+	w.meas.set(ob)
+	return &w.meas
+}
+
+// predict sets x to F*x and p to F*P*F^T + Q, for the transition and the process noise in the workspace.
+func (w *workspace) predict(x *mat.VecDense, p *mat.Dense) {
+	w.fx.MulVec(w.tr, x)
+	x.CopyVec(w.fx)
+	w.fp.Mul(w.tr, p)
+	p.Mul(w.fp, w.trT)
+	p.Add(p, w.q)
+	symmetrize(p)
+}
+
+// factorize computes the innovation y = z - H*x, the Cholesky factor L of its covariance
+// S = H*P*H^T + scale*R and the whitened innovation L^-1*y. It returns false if S is not positive definite.
+func (w *workspace) factorize(x *mat.VecDense, p *mat.Dense, m *measurement, r *mat.Dense, scale float64) bool {
+	pd := p.RawMatrix()
+	for j, i := range m.idx {
+		w.y[j] = m.z.AtVec(j) - x.AtVec(i)
+		for l, c := range m.idx[:j+1] {
+			w.l[j*_N+l] = pd.Data[i*pd.Stride+c] + scale*r.At(j, l)
+		}
+	}
+	if !cholesky(w.l[:], _N, len(m.idx)) {
+		return false
+	}
+	forward(w.l[:], _N, len(m.idx), w.e[:], w.y[:])
+	return true
+}
+
+// whitened returns the whitened innovation of the factorized measurement with m components.
+func (w *workspace) whitened(m int) []float64 {
+	return w.e[:m]
+}
+
+// sumSquares returns the sum of the squares of the values.
+func sumSquares(v []float64) float64 {
+	var s float64
+	for _, x := range v {
+		s += x * x
+	}
+	return s
+}
+
+// correct updates x and p with the factorized measurement, r is scaled by scale.
+func (w *workspace) correct(x *mat.VecDense, p *mat.Dense, m *measurement, r *mat.Dense, scale float64, form UpdateForm) {
+	n := w.n
+	k := len(m.idx)
+	pd := p.RawMatrix()
+
+	// K = P*H^T*S^-1, row by row: S*K[i]^T = (P*H^T)[i]^T.
+	var ph [_N]float64
+	for i := 0; i < n; i++ {
+		for j, c := range m.idx {
+			ph[j] = pd.Data[i*pd.Stride+c]
+		}
+		ki := w.k[i*_N : i*_N+k]
+		forward(w.l[:], _N, k, ki, ph[:])
+		backward(w.l[:], _N, k, ki, ki)
+	}
+
+	// x = x + K*y.
+	for i := 0; i < n; i++ {
+		var v float64
+		for j := 0; j < k; j++ {
+			v += w.k[i*_N+j] * w.y[j]
+		}
+		x.SetVec(i, x.AtVec(i)+v)
+	}
+
+	// (I-K*H)*P = P - K*(H*P).
+	for i := 0; i < n; i++ {
+		for c := 0; c < n; c++ {
+			v := pd.Data[i*pd.Stride+c]
+			for j, s := range m.idx {
+				v -= w.k[i*_N+j] * pd.Data[s*pd.Stride+c]
+			}
+			w.ap[i*n+c] = v
+		}
+	}
+	if form == SimpleForm {
+		for i := 0; i < n; i++ {
+			copy(pd.Data[i*pd.Stride:i*pd.Stride+n], w.ap[i*n:i*n+n])
+		}
+		symmetrize(p)
+		return
+	}
+
+	// Joseph form, (I-K*H)*P*(I-K*H)^T + K*R*K^T = A - A*H^T*K^T + K*R*K^T for A = (I-K*H)*P.
+	for i := 0; i < n; i++ {
+		for l := 0; l < k; l++ {
+			var v float64
+			for j := 0; j < k; j++ {
+				v += w.k[i*_N+j] * r.At(j, l)
+			}
+			w.kr[i*_N+l] = scale * v
+		}
+	}
+	for i := 0; i < n; i++ {
+		for c := 0; c < n; c++ {
+			v := w.ap[i*n+c]
+			for j, s := range m.idx {
+				v += (w.kr[i*_N+j] - w.ap[i*n+s]) * w.k[c*_N+j]
+			}
+			pd.Data[i*pd.Stride+c] = v
+		}
+	}
+	symmetrize(p)
+}
+
+// cholesky replaces the lower triangle of the m by m matrix a, with the given row stride, with
+// its Cholesky factor L, a = L*L^T. It returns false if a is not positive definite.
+func cholesky(a []float64, stride, m int) bool {
+	for j := 0; j < m; j++ {
+		d := a[j*stride+j]
+		for k := 0; k < j; k++ {
+			d -= a[j*stride+k] * a[j*stride+k]
+		}
+		if !(d > 0.0) {
+			return false
+		}
+		d = math.Sqrt(d)
+		a[j*stride+j] = d
+		for i := j + 1; i < m; i++ {
+			v := a[i*stride+j]
+			for k := 0; k < j; k++ {
+				v -= a[i*stride+k] * a[j*stride+k]
+			}
+			a[i*stride+j] = v / d
+		}
+	}
+	return true
+}
+
+// forward sets dst to L^-1*b for the m by m lower triangular L, dst and b may be the same.
+func forward(l []float64, stride, m int, dst, b []float64) {
+	for i := 0; i < m; i++ {
+		v := b[i]
+		for k := 0; k < i; k++ {
+			v -= l[i*stride+k] * dst[k]
+		}
+		dst[i] = v / l[i*stride+i]
+	}
+}
+
+// backward sets dst to L^-T*b for the m by m lower triangular L, dst and b may be the same.
+func backward(l []float64, stride, m int, dst, b []float64) {
+	for i := m - 1; i >= 0; i-- {
+		v := b[i]
+		for k := i + 1; k < m; k++ {
+			v -= l[k*stride+i] * dst[k]
+		}
+		dst[i] = v / l[i*stride+i]
+	}
+}
@@ -0,0 +1,65 @@
+package kalman
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestWorkspaceCorrect(t *testing.T) {
+	// The update with the workspace is the same as the general one with the measurement matrix.
+	assert := assert.New(t)
+	rnd := rand.New(rand.NewSource(1))
+	for _, form := range []UpdateForm{JosephForm, SimpleForm} {
+		for _, components := range []Component{ComponentAll, ComponentPosition, ComponentY | ComponentVX | ComponentVZ} {
+			n := _N
+			var a mat.Dense
+			a.Apply(func(i, j int, v float64) float64 { return rnd.NormFloat64() }, mat.NewDense(n, n, nil))
+			p := mat.NewDense(n, n, nil)
+			p.Mul(&a, a.T())
+			x := mat.NewVecDense(n, nil)
+			for i := 0; i < n; i++ {
+				x.SetVec(i, rnd.NormFloat64())
+			}
+			m := newMeasurement(&Observed{
+				X: 1.0, Y: 2.0, Z: 3.0, VX: 4.0, VY: 5.0, VZ: 6.0,
+				XA: 1.0, YA: 2.0, ZA: 3.0, VXA: 0.5, VYA: 0.5, VZA: 0.5,
+				Components: components,
+			})
+			if len(m.idx) > 1 {
+				m.r.Set(0, 1, 0.3)
+				m.r.Set(1, 0, 0.3)
+			}
+
+			x1, p1 := mat.VecDenseCopyOf(x), mat.DenseCopyOf(p)
+			var r mat.Dense
+			r.Scale(2.0, m.r)
+			assert.NoError(correct(x1, p1, m.z, m.h(n), &r, form))
+
+			w := newWorkspace(n)
+			assert.True(w.factorize(x, p, m, m.r, 2.0))
+			y, s := innovation(x, p, m.z, m.h(n), &r)
+			nis, err := mahalanobis(y, s)
+			assert.NoError(err)
+			assert.InDelta(nis, sumSquares(w.whitened(len(m.idx))), 1e-9)
+			w.correct(x, p, m, m.r, 2.0, form)
+			assert.True(mat.EqualApprox(x1, x, 1e-9))
+			assert.True(mat.EqualApprox(p1, p, 1e-9))
+		}
+	}
+}
+
+func TestCholesky(t *testing.T) {
+	assert := assert.New(t)
+	a := []float64{4.0, 0.0, 2.0, 5.0}
+	assert.True(cholesky(a, 2, 2))
+	assert.Equal([]float64{2.0, 0.0, 1.0, 2.0}, a)
+	b := []float64{3.0, 5.0}
+	forward(a, 2, 2, b, b)
+	backward(a, 2, 2, b, b)
+	// (4 2; 2 5)^-1 * (3 5) = (0.3125 0.875).
+	assert.InDeltaSlice([]float64{0.3125, 0.875}, b, 1e-12)
+	assert.False(cholesky([]float64{1.0, 0.0, 2.0, 1.0}, 2, 2))
+}